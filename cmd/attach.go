@@ -0,0 +1,30 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// attachCmd represents the attach command
+var attachCmd = &cobra.Command{
+	Use:   "attach <session>",
+	Short: "Attach to a persistent session in the dev container",
+	Long: `Attach the terminal to a persistent session in the dev container, as
+started with "devsh open --session <name>". See "devsh sessions" for the
+sessions that are running.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configLoad(cmd)
+		record, _ := cmd.Flags().GetString("record")
+		muxOpen(cfg, args[0], false, record)
+		statusDisplay(cfg)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(attachCmd)
+
+	attachCmd.Flags().String("record", "", "Record the session to this file (asciicast v2)")
+}
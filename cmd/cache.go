@@ -0,0 +1,248 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	cacheScopeGlobal  = "global"
+	cacheScopeProject = "project"
+
+	cacheVolumePrefix = "devsh-cache-"
+)
+
+// cacheMount is a cache volume mounted at a path inside the dev container.
+type cacheMount struct {
+	name string
+	path string
+}
+
+// cachePresets maps the names of the built-in caches to the cache paths of
+// the corresponding toolchains in their official docker images.
+var cachePresets = map[string][]cacheMount{
+	"go": {
+		{"go-mod", "/go/pkg/mod"},
+		{"go-build", "/root/.cache/go-build"},
+	},
+	"node": {
+		{"npm", "/root/.npm"},
+	},
+	"python": {
+		{"pip", "/root/.cache/pip"},
+	},
+	"rust": {
+		{"cargo-registry", "/usr/local/cargo/registry"},
+		{"cargo-git", "/usr/local/cargo/git"},
+	},
+	"ruby": {
+		{"bundle", "/usr/local/bundle"},
+	},
+}
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache volumes of dev containers",
+	Long: `Manage the named volumes devsh creates for the caches listed in the caches key.
+
+Cache volumes outlive the dev containers, so that toolchain caches (e.g. Go
+modules or npm packages) survive "devsh stop". Depending on cache_scope, a
+cache volume is either shared by all projects (global, the default) or used by
+a single project (project).
+`,
+}
+
+// cacheLsCmd represents the cache ls command
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the cache volumes",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VOLUME\tCACHE\tSCOPE\tPROJECT")
+		for _, v := range cacheVolumes() {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.volume, v.cache, v.scope, v.project)
+		}
+		w.Flush()
+	},
+}
+
+// cacheRmCmd represents the cache rm command
+var cacheRmCmd = &cobra.Command{
+	Use:   "rm [cache or volume]...",
+	Short: "Remove cache volumes",
+	Long: `Remove cache volumes. Without arguments, the project-scoped cache volumes
+of the project in the current folder are removed; cache volumes shared by all
+projects (cache_scope global) are only removed when selected by their volume
+name or cache name (e.g. go-mod), or with --all, which removes all cache
+volumes. A cache name does not select the project-scoped cache volumes of
+other projects, remove them by their volume name.
+
+Volumes that are in use by a dev container cannot be removed; stop the dev
+container first.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+
+		var names []string
+		switch {
+		case all:
+			for _, v := range cacheVolumes() {
+				names = append(names, v.volume)
+			}
+		case len(args) > 0:
+			volumes := cacheVolumes()
+			for _, arg := range args {
+				found := false
+				for _, v := range volumes {
+					// a cache name selects the shared volume and the
+					// project-scoped one of this project, not the ones of other
+					// projects
+					own := v.scope != cacheScopeProject || v.project == configProjectDir()
+					if v.volume == arg || v.cache == arg && own {
+						names = append(names, v.volume)
+						found = true
+					}
+				}
+				if !found {
+					log.Fatalf("ERROR: Cache volume %s is not found", arg)
+				}
+			}
+		default:
+			// shared cache volumes are only removed when selected explicitly,
+			// as other projects use them as well
+			for _, v := range cacheVolumes() {
+				if v.scope == cacheScopeProject && v.project == configProjectDir() {
+					names = append(names, v.volume)
+				}
+			}
+			if len(names) == 0 {
+				fmt.Printf("* The project has no cache volumes of its own, remove shared cache volumes by name or with --all\n")
+			}
+		}
+
+		for _, name := range slices.Compact(slices.Sorted(slices.Values(names))) {
+			dockerRunCmd(dockerConstructCmd("volume", []string{"rm"}, shellQuote(name)))
+			fmt.Printf("* Removed cache volume %s\n", name)
+		}
+	},
+}
+
+// cacheMounts returns the cache volumes configured for the dev container,
+// with the presets expanded. A cache is either the name of a preset (e.g. go)
+// or given as <name>:<path>.
+func cacheMounts(cfg ConfigValues) []cacheMount {
+	var mounts []cacheMount
+	for _, cache := range cfg.Caches {
+		if preset, ok := cachePresets[cache]; ok {
+			mounts = append(mounts, preset...)
+			continue
+		}
+		name, path, ok := strings.Cut(cache, ":")
+		if !ok || name == "" || !strings.HasPrefix(path, "/") {
+			log.Fatalf("ERROR: Invalid cache %s, expected one of the presets (%s) or <name>:<path>",
+				cache, strings.Join(slices.Sorted(maps.Keys(cachePresets)), ", "))
+		}
+		mounts = append(mounts, cacheMount{name, path})
+	}
+	return mounts
+}
+
+// cacheScope returns the configured scope of the cache volumes.
+func cacheScope(cfg ConfigValues) string {
+	switch cfg.CacheScope {
+	case "", cacheScopeGlobal:
+		return cacheScopeGlobal
+	case cacheScopeProject:
+		return cacheScopeProject
+	}
+	log.Fatalf("ERROR: Invalid cache_scope %s, expected %s or %s", cfg.CacheScope, cacheScopeGlobal, cacheScopeProject)
+	return ""
+}
+
+// cacheVolumeName returns the name of the volume for a cache, e.g.
+// devsh-cache-go-mod, or devsh-cache-devsh-a1b2-go-mod for a project-scoped
+// cache.
+func cacheVolumeName(cfg ConfigValues, cache string) string {
+	if cacheScope(cfg) == cacheScopeProject {
+		return cacheVolumePrefix + cfg.Name + "-" + configProjectPathHash() + "-" + cache
+	}
+	return cacheVolumePrefix + cache
+}
+
+// cacheVolumeOpts returns the options that mount the cache volumes into the
+// dev container.
+func cacheVolumeOpts(cfg ConfigValues) []string {
+	var opts []string
+	for _, m := range cacheMounts(cfg) {
+		opts = append(opts, "--volume "+shellQuote(cacheVolumeName(cfg, m.name)+":"+m.path))
+	}
+	return opts
+}
+
+// cacheCreateVolumes creates the cache volumes of the dev container that do
+// not exist yet. The volumes are labelled so that they can be found by the
+// cache commands.
+func cacheCreateVolumes(cfg ConfigValues) {
+	scope := cacheScope(cfg)
+	for _, m := range cacheMounts(cfg) {
+		name := cacheVolumeName(cfg, m.name)
+		if dockerIsVolumePresent(name) {
+			continue
+		}
+		opts := []string{
+			"create",
+			dockerLabelOpt("cache", m.name),
+			dockerLabelOpt("scope", scope),
+		}
+		if scope == cacheScopeProject {
+			opts = append(opts, dockerLabelOpt("project", configProjectDir()))
+		}
+		dockerRunCmd(dockerConstructCmd("volume", opts, shellQuote(name)))
+	}
+}
+
+// cacheVolume describes an existing cache volume.
+type cacheVolume struct {
+	volume  string
+	cache   string
+	scope   string
+	project string
+}
+
+// cacheVolumes returns all cache volumes created by devsh.
+func cacheVolumes() []cacheVolume {
+	opts := []string{
+		"ls",
+		"--filter label=" + dockerLabelPrefix + "cache",
+		"--format " + shellQuote(fmt.Sprintf(`{{.Name}}|{{.Label "%[1]scache"}}|{{.Label "%[1]sscope"}}|{{.Label "%[1]sproject"}}`, dockerLabelPrefix)),
+	}
+	out := dockerRunCmd(dockerConstructCmd("volume", opts))
+
+	var volumes []cacheVolume
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 4 {
+			continue
+		}
+		volumes = append(volumes, cacheVolume{fields[0], fields[1], fields[2], fields[3]})
+	}
+	return volumes
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheLsCmd, cacheRmCmd)
+
+	cacheRmCmd.Flags().Bool("all", false, "Remove all cache volumes")
+}
@@ -0,0 +1,570 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// composeFilenames lists the default names of a compose file, in order of
+// preference.
+var composeFilenames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// ComposeConfig refers to a service of a docker compose file that is used as
+// the dev container. The other services of the file become its services.
+type ComposeConfig struct {
+	File    string `yaml:"file,omitempty"`
+	Service string `yaml:"service,omitempty"`
+}
+
+// composeFile holds the parts of a compose file that devsh translates into its
+// own config.
+type composeFile struct {
+	Name     string                    `yaml:"name"`
+	Services map[string]composeService `yaml:"services"`
+	Networks map[string]composeNamed   `yaml:"networks"`
+	Volumes  map[string]composeNamed   `yaml:"volumes"`
+}
+
+// composeService is a service of a compose file. Keys that take several forms
+// (e.g. a list or a map) are kept as YAML nodes and translated separately.
+type composeService struct {
+	Image         string              `yaml:"image"`
+	Build         yaml.Node           `yaml:"build"`
+	Ports         []yaml.Node         `yaml:"ports"`
+	Volumes       []yaml.Node         `yaml:"volumes"`
+	Environment   yaml.Node           `yaml:"environment"`
+	Networks      yaml.Node           `yaml:"networks"`
+	DependsOn     yaml.Node           `yaml:"depends_on"`
+	Healthcheck   *composeHealthcheck `yaml:"healthcheck"`
+	User          string              `yaml:"user"`
+	Hostname      string              `yaml:"hostname"`
+	ContainerName string              `yaml:"container_name"`
+	DNS           yaml.Node           `yaml:"dns"`
+	PullPolicy    string              `yaml:"pull_policy"`
+}
+
+// composeServiceKeys lists the keys of a compose service that are translated
+// or have no effect, so they are not reported as ignored.
+var composeServiceKeys = []string{
+	"image", "build", "ports", "volumes", "environment", "networks", "depends_on",
+	"healthcheck", "user", "hostname", "container_name", "dns", "restart",
+	"pull_policy",
+}
+
+// composeDevKeys lists the keys of the compose service used as the dev
+// container that are replaced by devsh: it runs an idle shell in the project
+// folder instead of the command of the service.
+var composeDevKeys = []string{"working_dir", "command", "entrypoint", "stdin_open", "tty"}
+
+// composeConsistencyModes lists the modes of a bind mount that only tune the
+// consistency of the files on Docker Desktop and are ignored elsewhere, so a
+// mount of the project folder with one of them is the project folder itself.
+var composeConsistencyModes = []string{"cached", "delegated", "consistent"}
+
+// composeHealthcheck is the health check of a compose service.
+type composeHealthcheck struct {
+	Test        yaml.Node `yaml:"test"`
+	Interval    string    `yaml:"interval"`
+	Timeout     string    `yaml:"timeout"`
+	Retries     int       `yaml:"retries"`
+	StartPeriod string    `yaml:"start_period"`
+	Disable     bool      `yaml:"disable"`
+}
+
+// composeNamed is a top-level network or volume of a compose file.
+type composeNamed struct {
+	Name     string `yaml:"name"`
+	External bool   `yaml:"external"`
+}
+
+// composeLoad reads the compose file referred to by compose and translates it
+// into the devsh config: the selected service becomes the dev container, the
+// other services become its services. dir is the folder of the config file
+// that refers to the compose file.
+func composeLoad(compose *ComposeConfig, dir string) (ConfigValues, error) {
+	var cfg ConfigValues
+	if compose.Service == "" {
+		return cfg, fmt.Errorf("compose.service is not set")
+	}
+
+	path, err := composePath(compose.File, dir)
+	if err != nil {
+		return cfg, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	var f composeFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	var raw struct {
+		Services map[string]map[string]any `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dev, ok := f.Services[compose.Service]
+	if !ok {
+		return cfg, fmt.Errorf("%s has no service %s", path, compose.Service)
+	}
+	t := composeTranslator{file: &f, path: path, dir: filepath.Dir(path)}
+	for _, name := range slices.Sorted(maps.Keys(raw.Services)) {
+		var ignored []string
+		for _, key := range slices.Sorted(maps.Keys(raw.Services[name])) {
+			if !slices.Contains(composeServiceKeys, key) && !(name == compose.Service && slices.Contains(composeDevKeys, key)) {
+				ignored = append(ignored, key)
+			}
+		}
+		if len(ignored) > 0 {
+			t.warn("service %s: ignoring unsupported keys: %s", name, strings.Join(ignored, ", "))
+		}
+	}
+
+	cfg.Image = dev.Image
+	cfg.Build = t.build(compose.Service, dev.Build)
+	cfg.Ports = t.ports(compose.Service, dev.Ports)
+	cfg.ContainerDir, cfg.Volumes = t.volumes(compose.Service, dev.Volumes)
+	cfg.Env = t.environment(compose.Service, dev.Environment)
+	cfg.Network = t.network(compose.Service, dev.Networks)
+	cfg.DependsOn = t.dependsOn(compose.Service, dev.DependsOn)
+	cfg.Healthcheck = t.healthcheck(compose.Service, dev.Healthcheck)
+	cfg.Pull = t.pullPolicy(compose.Service, dev.PullPolicy)
+	cfg.User = dev.User
+	cfg.ContainerHost = dev.Hostname
+	cfg.ContainerName = dev.ContainerName
+	if dns := t.strings(compose.Service, "dns", dev.DNS); len(dns) > 0 {
+		cfg.DNS = dns[0]
+		if len(dns) > 1 {
+			t.warn("service %s: only the first DNS server %s is used", compose.Service, dns[0])
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(f.Services)) {
+		if name == compose.Service {
+			continue
+		}
+		svc := f.Services[name]
+		if svc.Image == "" {
+			t.warn("service %s: ignoring the service, it has no image (services cannot be built)", name)
+			continue
+		}
+		containerDir, volumes := t.volumes(name, svc.Volumes)
+		if containerDir != "" {
+			volumes = append(volumes, configProjectDir()+":"+containerDir)
+		}
+		if cfg.Services == nil {
+			cfg.Services = map[string]ServiceConfig{}
+		}
+		cfg.Services[name] = ServiceConfig{
+			Image:       svc.Image,
+			Env:         t.environment(name, svc.Environment),
+			Ports:       t.ports(name, svc.Ports),
+			Volumes:     volumes,
+			DependsOn:   t.dependsOn(name, svc.DependsOn),
+			Healthcheck: t.healthcheck(name, svc.Healthcheck),
+		}
+	}
+	if cfg.Network == "" && len(f.Networks) > 0 {
+		cfg.Network = networkProject
+	}
+
+	return cfg, nil
+}
+
+// composePath returns the path of the compose file given in the compose.file
+// key, relative to dir, or of the first default compose file in dir.
+func composePath(file string, dir string) (string, error) {
+	if file != "" {
+		file = expandTilde(file)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return file, nil
+	}
+	for _, name := range composeFilenames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no compose file found in %s, set compose.file", dir)
+}
+
+// composeTranslator translates the values of the services of a compose file,
+// reporting the ones that devsh cannot translate as config warnings.
+type composeTranslator struct {
+	file *composeFile
+	path string
+	dir  string // folder of the compose file, relative paths are resolved against it
+}
+
+// warn adds a config warning about the compose file.
+func (t *composeTranslator) warn(format string, args ...any) {
+	configWarnings = append(configWarnings, t.path+": "+fmt.Sprintf(format, args...))
+}
+
+// build translates the build key, either a context path or an object.
+func (t *composeTranslator) build(service string, node yaml.Node) *BuildConfig {
+	if node.Kind == 0 {
+		return nil
+	}
+	var build struct {
+		Context    string    `yaml:"context"`
+		Dockerfile string    `yaml:"dockerfile"`
+		Args       yaml.Node `yaml:"args"`
+		Target     string    `yaml:"target"`
+	}
+	if node.Kind == yaml.ScalarNode {
+		build.Context = node.Value
+	} else if err := node.Decode(&build); err != nil {
+		t.warn("service %s: ignoring build: %s", service, err)
+		return nil
+	}
+
+	cfg := &BuildConfig{
+		Context: t.hostPath(build.Context),
+		Target:  build.Target,
+		Args:    t.keyValues(service, "build.args", build.Args),
+	}
+	if build.Dockerfile != "" {
+		// unlike docker build --file, the dockerfile is relative to the context
+		cfg.Dockerfile = build.Dockerfile
+		if !filepath.IsAbs(cfg.Dockerfile) {
+			cfg.Dockerfile = filepath.Join(cfg.Context, cfg.Dockerfile)
+		}
+	}
+	return cfg
+}
+
+// ports translates the ports key, given in the short form of docker run
+// --publish or as objects.
+func (t *composeTranslator) ports(service string, nodes []yaml.Node) []string {
+	var ports []string
+	for _, node := range nodes {
+		if node.Kind == yaml.ScalarNode {
+			ports = append(ports, node.Value)
+			continue
+		}
+		var port struct {
+			Target    string `yaml:"target"`
+			Published string `yaml:"published"`
+			HostIp    string `yaml:"host_ip"`
+			Protocol  string `yaml:"protocol"`
+		}
+		if err := node.Decode(&port); err != nil || port.Target == "" {
+			t.warn("service %s: ignoring port at line %d", service, node.Line)
+			continue
+		}
+		m := portsMapping{ip: port.HostIp, hostPort: port.Published, containerPort: port.Target, proto: port.Protocol}
+		if m.proto == "" {
+			m.proto = "tcp"
+		}
+		ports = append(ports, m.String())
+	}
+	return ports
+}
+
+// volumes translates the volumes key, given in the short form of docker run
+// --volume or as objects. Relative host paths are resolved against the folder
+// of the compose file, and named volumes get the names compose gives them. A
+// mount of the project folder is returned as the container folder instead,
+// as devsh mounts the project folder itself.
+func (t *composeTranslator) volumes(service string, nodes []yaml.Node) (string, []string) {
+	var containerDir string
+	var volumes []string
+	for _, node := range nodes {
+		var source, target, mode string
+		if node.Kind == yaml.ScalarNode {
+			parts := strings.Split(node.Value, ":")
+			switch len(parts) {
+			case 1:
+				// an anonymous volume
+				volumes = append(volumes, node.Value)
+				continue
+			case 2:
+				source, target = parts[0], parts[1]
+			default:
+				source, target, mode = parts[0], parts[1], strings.Join(parts[2:], ":")
+			}
+		} else {
+			var volume struct {
+				Type     string `yaml:"type"`
+				Source   string `yaml:"source"`
+				Target   string `yaml:"target"`
+				ReadOnly bool   `yaml:"read_only"`
+			}
+			if err := node.Decode(&volume); err != nil || volume.Target == "" {
+				t.warn("service %s: ignoring volume at line %d", service, node.Line)
+				continue
+			}
+			if volume.Type != "" && volume.Type != "bind" && volume.Type != "volume" {
+				t.warn("service %s: ignoring volume %s, type %s is not supported", service, volume.Target, volume.Type)
+				continue
+			}
+			source, target = volume.Source, volume.Target
+			if volume.ReadOnly {
+				mode = "ro"
+			}
+			if source == "" {
+				volumes = append(volumes, target)
+				continue
+			}
+		}
+
+		if strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~") || filepath.IsAbs(source) {
+			source = t.hostPath(source)
+			if source == configProjectDir() && (mode == "" || slices.Contains(composeConsistencyModes, mode)) && containerDir == "" {
+				containerDir = target
+				continue
+			}
+		} else {
+			source = t.volumeName(source)
+		}
+		volume := source + ":" + target
+		if mode != "" {
+			volume += ":" + mode
+		}
+		volumes = append(volumes, volume)
+	}
+	return containerDir, volumes
+}
+
+// volumeName returns the name compose gives to a named volume: the name set
+// in the top-level volumes, or else the volume prefixed with the compose
+// project name.
+func (t *composeTranslator) volumeName(volume string) string {
+	if v, ok := t.file.Volumes[volume]; ok {
+		if v.Name != "" {
+			return v.Name
+		}
+		if v.External {
+			return volume
+		}
+	}
+	return t.projectName() + "_" + volume
+}
+
+// projectName returns the compose project name like compose derives it:
+// COMPOSE_PROJECT_NAME, or else the top-level name of the compose file, or
+// else the folder of the compose file, normalized to lowercase letters,
+// digits, dashes and underscores.
+func (t *composeTranslator) projectName() string {
+	name := os.Getenv("COMPOSE_PROJECT_NAME")
+	if name == "" {
+		name = t.file.Name
+	}
+	if name == "" {
+		name = filepath.Base(t.dir)
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return -1
+	}, name)
+	// the name must start with a letter or a digit
+	return strings.TrimLeft(name, "_-")
+}
+
+// hostPath resolves a path on the host against the folder of the compose file.
+func (t *composeTranslator) hostPath(path string) string {
+	path = expandTilde(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(t.dir, path)
+	}
+	return path
+}
+
+// environment translates the environment key, given as a map or as a list of
+// KEY=VALUE entries. Variables without a value take the value on the host,
+// like in compose.
+func (t *composeTranslator) environment(service string, node yaml.Node) map[string]string {
+	return t.keyValues(service, "environment", node)
+}
+
+// keyValues translates a key given as a map or as a list of KEY=VALUE entries.
+func (t *composeTranslator) keyValues(service string, key string, node yaml.Node) map[string]string {
+	entries := map[string]string{}
+	switch node.Kind {
+	case 0:
+		return nil
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name, value := node.Content[i].Value, node.Content[i+1]
+			if value.Tag == "!!null" {
+				entries[name] = interpolateEscape(os.Getenv(name))
+			} else {
+				entries[name] = value.Value
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			name, value, ok := strings.Cut(item.Value, "=")
+			if !ok {
+				value = interpolateEscape(os.Getenv(name))
+			}
+			entries[name] = value
+		}
+	default:
+		t.warn("service %s: ignoring %s, expected a map or a list", service, key)
+		return nil
+	}
+	return entries
+}
+
+// strings translates a key given as a single string or as a list of strings.
+func (t *composeTranslator) strings(service string, key string, node yaml.Node) []string {
+	switch node.Kind {
+	case 0:
+		return nil
+	case yaml.ScalarNode:
+		return []string{node.Value}
+	case yaml.SequenceNode:
+		var values []string
+		for _, item := range node.Content {
+			values = append(values, item.Value)
+		}
+		return values
+	}
+	t.warn("service %s: ignoring %s, expected a string or a list", service, key)
+	return nil
+}
+
+// network translates the networks key, given as a list or a map. devsh
+// attaches the dev container and its services to a single network: an
+// external network keeps its name, the networks defined by the compose file
+// become the network of the project.
+func (t *composeTranslator) network(service string, node yaml.Node) string {
+	var names []string
+	switch node.Kind {
+	case 0:
+		return ""
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			names = append(names, item.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			names = append(names, node.Content[i].Value)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	if len(names) > 1 {
+		t.warn("service %s: attaching to the network %s only, devsh uses a single network", service, names[0])
+	}
+
+	network := t.file.Networks[names[0]]
+	if network.External {
+		if network.Name != "" {
+			return network.Name
+		}
+		return names[0]
+	}
+	return networkProject
+}
+
+// pullPolicy translates the pull_policy key. It only applies to the dev
+// container, devsh has a single pull policy.
+func (t *composeTranslator) pullPolicy(service string, policy string) string {
+	switch policy {
+	case "", "build":
+		return ""
+	case "if_not_present":
+		return pullMissing
+	case pullAlways, pullMissing, pullNever, pullDaily:
+		return policy
+	}
+	t.warn("service %s: ignoring pull_policy %s", service, policy)
+	return ""
+}
+
+// dependsOn translates the depends_on key, given as a list of services, or as
+// a map with the condition of each service.
+func (t *composeTranslator) dependsOn(service string, node yaml.Node) map[string]string {
+	dependsOn := map[string]string{}
+	switch node.Kind {
+	case 0:
+		return nil
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			dependsOn[item.Value] = healthStarted
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			var dep struct {
+				Condition string `yaml:"condition"`
+			}
+			node.Content[i+1].Decode(&dep)
+			switch dep.Condition {
+			case "", "service_started":
+				dependsOn[name] = healthStarted
+			case "service_healthy":
+				dependsOn[name] = healthHealthy
+			default:
+				t.warn("service %s: condition %s of %s is not supported, waiting for it to be started", service, dep.Condition, name)
+				dependsOn[name] = healthStarted
+			}
+		}
+	}
+	return dependsOn
+}
+
+// healthcheck translates the healthcheck key. The test is given either as a
+// shell command, or as a list starting with CMD, CMD-SHELL or NONE.
+func (t *composeTranslator) healthcheck(service string, hc *composeHealthcheck) *HealthcheckConfig {
+	if hc == nil || hc.Disable {
+		return nil
+	}
+	var test string
+	switch hc.Test.Kind {
+	case yaml.ScalarNode:
+		test = hc.Test.Value
+	case yaml.SequenceNode:
+		var args []string
+		for _, item := range hc.Test.Content {
+			args = append(args, item.Value)
+		}
+		switch {
+		case len(args) == 0 || args[0] == "NONE":
+			return nil
+		case args[0] == "CMD-SHELL":
+			test = strings.Join(args[1:], " ")
+		case args[0] == "CMD":
+			quoted := make([]string, 0, len(args)-1)
+			for _, arg := range args[1:] {
+				quoted = append(quoted, shellQuote(arg))
+			}
+			test = strings.Join(quoted, " ")
+		default:
+			t.warn("service %s: ignoring healthcheck, the test must start with CMD, CMD-SHELL or NONE", service)
+			return nil
+		}
+	}
+	if test == "" {
+		return nil
+	}
+	return &HealthcheckConfig{
+		Test:        test,
+		Interval:    hc.Interval,
+		Timeout:     hc.Timeout,
+		Retries:     hc.Retries,
+		StartPeriod: hc.StartPeriod,
+	}
+}
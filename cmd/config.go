@@ -8,9 +8,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -25,6 +31,8 @@ const (
 // config file, the project .devsh file, and command-line flags.
 type ConfigValues struct {
 	Image         string   `yaml:"image,omitempty"`
+	Pull          string   `yaml:"pull,omitempty"`
+	Snapshot      string   `yaml:"snapshot,omitempty"`
 	Name          string   `yaml:"name,omitempty"`
 	ShellCmd      string   `yaml:"shell_cmd,omitempty"`
 	ContainerHost string   `yaml:"container_host,omitempty"`
@@ -34,8 +42,62 @@ type ConfigValues struct {
 	Volumes       []string `yaml:"volumes,omitempty"`
 	Network       string   `yaml:"network,omitempty"`
 	DNS           string   `yaml:"dns,omitempty"`
+
+	Env           map[string]string `yaml:"env,omitempty"`
+	User          string            `yaml:"user,omitempty"`
+	Build         *BuildConfig      `yaml:"build,omitempty"`
+	PostCreateCmd string            `yaml:"post_create_cmd,omitempty"`
+
+	// Healthcheck and DependsOn tell when the dev container is ready for the
+	// shell: once it is healthy and the services it depends on meet their
+	// conditions, waiting at most WaitTimeout.
+	Healthcheck *HealthcheckConfig `yaml:"healthcheck,omitempty"`
+	DependsOn   map[string]string  `yaml:"depends_on,omitempty"`
+	WaitTimeout string             `yaml:"wait_timeout,omitempty"`
+
+	Caches     []string `yaml:"caches,omitempty"`
+	CacheScope string   `yaml:"cache_scope,omitempty"`
+
+	PersistHome *bool `yaml:"persist_home,omitempty"`
+	SSHAgent    *bool `yaml:"ssh_agent,omitempty"`
+	GitConfig   *bool `yaml:"git_config,omitempty"`
+
+	// Dotfiles is meant to be set in the global config, as it is specific
+	// to the user rather than the project.
+	Dotfiles *DotfilesConfig `yaml:"dotfiles,omitempty"`
+
+	// Services are containers started alongside the dev container on the
+	// network of the project, e.g. databases.
+	Services map[string]ServiceConfig `yaml:"services,omitempty"`
+
+	// Compose refers to a service of a docker compose file, which is used as
+	// the dev container along with the other services of the file.
+	Compose *ComposeConfig `yaml:"compose,omitempty"`
+
+	// Extends refers to a base config file whose values are inherited by
+	// the file that extends it.
+	Extends string `yaml:"extends,omitempty"`
+
+	// Profile selects one of the Profiles, which is then overlaid on top of
+	// the configuration from the files.
+	Profile  string                  `yaml:"profile,omitempty"`
+	Profiles map[string]ConfigValues `yaml:"profiles,omitempty"`
+}
+
+// BuildConfig describes how to build the image for the dev container when it
+// is not available as a prebuilt image.
+type BuildConfig struct {
+	Context    string            `yaml:"context,omitempty"`
+	Dockerfile string            `yaml:"dockerfile,omitempty"`
+	Args       map[string]string `yaml:"args,omitempty"`
+	Target     string            `yaml:"target,omitempty"`
 }
 
+// configWarnings collects the non-fatal problems found while loading the
+// config, e.g. settings of a devcontainer.json that devsh does not support.
+// They are reported by configReportWarnings.
+var configWarnings []string
+
 // defaultConfigValues returns the built-in defaults. These have the lowest
 // priority and are only used when a value is not provided by any of the three
 // configuration sources.
@@ -55,10 +117,12 @@ The configuration is combined from the following sources, listed from the
 lowest priority to the highest:
 
   1. Built-in defaults
-  2. Global configuration file (default: ~/.config/devsh, overridable via
-     the DEVSH_CONFIG environment variable)
+  2. Global configuration file (default: ~/.config/devsh, or
+     ~/.config/devsh/config if it is a folder; overridable via the
+     DEVSH_CONFIG environment variable)
   3. Project configuration file (.devsh in the current folder)
-  4. Command-line flags
+  4. Environment variables (DEVSH_<KEY>, e.g. DEVSH_IMAGE or DEVSH_SHELL_CMD)
+  5. Command-line flags
 
 For every parameter, the value from the highest-priority source that provides
 it takes precedence; values from lower-priority sources are inherited when a
@@ -66,18 +130,112 @@ higher-priority source does not set the parameter.
 
 The .devsh file is a YAML file with the following format (all keys are optional):
   image: # docker image to be used for dev container
+  pull: # when to pull the images: missing (default), always, daily or never
+  snapshot: # start from a snapshot of the dev container instead of the image: latest or its tag
   name: # name of the project, if omitted the directory name is used
   shell_cmd: # shell to start inside the dev container, e.g. /bin/bash
   container_host: # name of the host for the dev container
   container_dir: # path inside the dev container where the project is going to be mounted
   container_name: # human-readable name for the dev container in docker
-  ports: # ports of the container exposed on host
+  ports: # ports of the container exposed on host (auto:<port> picks a free host port)
   volumes: # additional volumes to be mounted inside the dev container
-  network: # docker network for the dev container
+  network: # docker network for the dev container (project or shared for a network managed by devsh)
   dns: # explicit DNS server to use for the dev container
+  env: # environment variables set in the dev container
+  user: # user to run the shell as inside the dev container
+  build: # build the image from a Dockerfile instead of using a prebuilt one
+    context: # build context (default: the project folder)
+    dockerfile: # path to the Dockerfile
+    args: # build arguments
+    target: # target build stage
+  post_create_cmd: # command run inside the dev container once it is created
+  healthcheck: # command docker runs to check that the dev container is healthy (test, interval, timeout, retries, start_period)
+  depends_on: # services to wait for before the shell opens, by name, with the condition: started or healthy
+  wait_timeout: # how long to wait for the dev container and the services to be ready (default: 2m)
+  caches: # toolchain caches kept in named volumes: presets (go, node, python, rust, ruby) or <name>:<path>
+  cache_scope: # global (default) to share the cache volumes between projects, or project
+  persist_home: # true to keep the home folder of the container user in a per-project volume
+  ssh_agent: # true to forward the SSH agent of the host into the dev container
+  git_config: # true to forward the git identity and safe git settings of the host
+  dotfiles: # dotfiles installed into the dev container once it is created (usually set in the global config)
+    path: # folder with the dotfiles on the host
+    install: # command that installs them, run inside the copied folder (default: install.sh, bootstrap.sh or setup.sh)
+  services: # containers started alongside the dev container on the project network, by name
+    <name>:
+      image: # docker image of the service
+      env: # environment variables set in the service container
+      ports: # ports of the service exposed on host
+      volumes: # volumes mounted inside the service container
+      depends_on: # services to wait for before the service starts, by name, with the condition: started or healthy
+      healthcheck: # command docker runs to check that the service is healthy
+        test: # shell command, healthy if it succeeds
+        interval: # time between checks, e.g. 5s
+        timeout: # time a check may take
+        retries: # failed checks until the service is unhealthy
+        start_period: # time to start up, during which failed checks are not counted
+  compose: # use a service of a docker compose file as the dev container, and its other services as services
+    file: # path to the compose file (default: compose.yaml or docker-compose.yml next to the config file)
+    service: # name of the service to use as the dev container
+  extends: # base config file (path or file:// URL) whose values are inherited
+  profile: # name of the profile to use by default
+  profiles: # named sets of values overlaid on top of the config when selected
+
+A config file may extend a base config file given as a path (relative to the
+extending file) or a file:// URL. Base files are resolved recursively, and the
+values of the extending file override the ones of its base.
+
+When there is no .devsh file, the config is read from .devcontainer/devcontainer.json
+(or .devcontainer.json) instead. A devcontainer.json can also be imported
+explicitly with "extends: .devcontainer/devcontainer.json".
+
+With the compose key, a service of a docker compose file is used as the dev
+container: its image, build, ports, volumes, environment, networks,
+depends_on and healthcheck are translated into the config, and the other
+services of the file become services of the dev container. Values set in the
+file override the ones translated from the compose file.
+
+Every key except extends, build, healthcheck, dotfiles, services, compose and
+profiles can be set with a DEVSH_<KEY> environment variable, named after the
+upper-cased key. List values are separated by commas (DEVSH_PORTS=8080:8080,9090:9090),
+env and depends_on entries are given as KEY=VALUE pairs (DEVSH_ENV=FOO=1,BAR=2),
+and boolean values as true or false. A comma within a value is escaped as \,
+(DEVSH_ENV=HOSTS=a\,b).
+
+Use --explain to show which source every value comes from.
+
+A profile is selected with the --profile flag, the DEVSH_PROFILE environment
+variable or the profile key, and its values override the ones from the config
+files. Every profile gets its own dev container, so several profiles of the
+same project can run at once.
+
+String values may refer to variables, which are expanded after the sources
+are combined:
+  ${VAR}            # environment variable, an error if it is not defined
+  ${VAR:-default}   # environment variable, or default if it is unset or empty
+  ${project.name}   # name of the project
+  ${project.dir}    # project folder on the host
+  ${project.hash}   # short hash of the project folder path
+  ${git.branch}     # current git branch of the project folder
+  $$                # a literal '$'
+
+Commands (shell_cmd, post_create_cmd, the test of a healthcheck and the
+install command of dotfiles) are expanded as well: write $${VAR} or $VAR for a
+variable of the shell in the container. Values passed from the host
+environment (--env NAME, or env entries without a value in a compose file)
+are taken literally.
+
+The config files can be edited with the get, set, unset, add and remove
+subcommands.
 `,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		cfg := configLoad(cmd)
+		cfg, layers := configLoadLayered(cmd)
+		configReportWarnings()
+
+		if explain, _ := cmd.Flags().GetBool("explain"); explain {
+			configExplain(cfg, layers)
+			return
+		}
 
 		configYaml, err := yaml.Marshal(cfg)
 		if err != nil {
@@ -88,28 +246,81 @@ The .devsh file is a YAML file with the following format (all keys are optional)
 	},
 }
 
+// configLayer is a set of config values provided by a single source.
+type configLayer struct {
+	source string
+	values ConfigValues
+}
+
 // Loads and returns a combined config for the project in the current folder.
+func configLoad(cmd *cobra.Command) ConfigValues {
+	cfg, _ := configLoadLayered(cmd)
+	return cfg
+}
+
+// configLoadLayered loads the config for the project in the current folder
+// and returns it along with the layers it is combined from.
 //
-// Values are merged from four layers, each overriding the previous one:
+// Values are merged from five layers, each overriding the previous one:
 //  1. built-in defaults
 //  2. global configuration file
 //  3. project configuration file (.devsh)
-//  4. command-line flags
+//  4. environment variables (DEVSH_<KEY>)
+//  5. command-line flags
 //
-// Finally, values that are still empty are filled with dynamically constructed
-// conventional defaults derived from the project directory.
-func configLoad(cmd *cobra.Command) ConfigValues {
-	cfg := defaultConfigValues()
+// When a profile is selected, its values are overlaid on top of the config
+// files, right beneath the environment variables.
+//
+// Variable references (e.g. ${HOME} or ${project.name}) are then expanded in
+// the merged values, and finally, values that are still empty are filled with
+// dynamically constructed conventional defaults derived from the project
+// directory.
+func configLoadLayered(cmd *cobra.Command) (ConfigValues, []configLayer) {
+	layers := []configLayer{
+		{"built-in defaults", defaultConfigValues()},
+		{"global config file " + configGlobalPath(), configLoadGlobal()},
+	}
+	if path := configLocalPath(); path != "" {
+		layers = append(layers, configLayer{"project config file " + path, configLoadLocal()})
+	}
+
+	var files ConfigValues
+	for _, layer := range layers {
+		files = mergeConfig(files, layer.values)
+	}
+
+	env := configLoadEnv()
+	flags := configLoadFlags(cmd)
+
+	// the profile can be selected by any source, the profile values are
+	// overlaid right beneath the environment and flags
+	profile := mergeConfig(mergeConfig(files, env), flags).Profile
+	if profile != "" {
+		layers = append(layers, configLayer{"profile " + profile, configProfile(files.Profiles, profile)})
+	}
 
-	cfg = mergeConfig(cfg, configLoadGlobal())
-	cfg = mergeConfig(cfg, configLoadLocal())
-	cfg = mergeConfig(cfg, configLoadFlags(cmd))
+	layers = append(layers,
+		configLayer{"environment variables", env},
+		configLayer{"command-line flags", flags},
+	)
+
+	var cfg ConfigValues
+	for _, layer := range layers {
+		cfg = mergeConfig(cfg, layer.values)
+	}
+	// the resulting config no longer carries the profile definitions
+	cfg.Profiles = nil
 
 	// fill values that are still empty with dynamically constructed defaults
 	if cfg.Name == "" {
 		cfg.Name = configDefaultProjectName()
 	}
 
+	cfg, err := configInterpolate(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to expand variables in config: %s", err)
+	}
+
 	if cfg.ContainerHost == "" {
 		cfg.ContainerHost = configDefaultContainerHost(cfg)
 	}
@@ -119,14 +330,18 @@ func configLoad(cmd *cobra.Command) ConfigValues {
 	if cfg.ContainerName == "" {
 		cfg.ContainerName = configDefaultContainerName(cfg)
 	}
+	if cfg.Image == "" && cfg.Build != nil {
+		cfg.Image = configDefaultImage(cfg)
+	}
 	if cfg.Network == "" {
 		cfg.Network = configDefaultNetwork(cfg)
 	}
+	cfg.Network = networkName(cfg)
 	if cfg.DNS == "" {
 		cfg.DNS = configDefaultDNS(cfg)
 	}
 
-	return cfg
+	return cfg, layers
 }
 
 // mergeConfig returns base with every field overridden by the corresponding
@@ -137,6 +352,12 @@ func mergeConfig(base, override ConfigValues) ConfigValues {
 	if override.Image != "" {
 		base.Image = override.Image
 	}
+	if override.Pull != "" {
+		base.Pull = override.Pull
+	}
+	if override.Snapshot != "" {
+		base.Snapshot = override.Snapshot
+	}
 	if override.Name != "" {
 		base.Name = override.Name
 	}
@@ -164,9 +385,102 @@ func mergeConfig(base, override ConfigValues) ConfigValues {
 	if override.DNS != "" {
 		base.DNS = override.DNS
 	}
+	if len(override.Env) > 0 {
+		// environment variables are merged by name
+		env := maps.Clone(base.Env)
+		if env == nil {
+			env = make(map[string]string, len(override.Env))
+		}
+		maps.Copy(env, override.Env)
+		base.Env = env
+	}
+	if override.User != "" {
+		base.User = override.User
+	}
+	if override.Build != nil {
+		base.Build = override.Build
+	}
+	if override.PostCreateCmd != "" {
+		base.PostCreateCmd = override.PostCreateCmd
+	}
+	if override.Healthcheck != nil {
+		base.Healthcheck = override.Healthcheck
+	}
+	if len(override.DependsOn) > 0 {
+		base.DependsOn = override.DependsOn
+	}
+	if override.WaitTimeout != "" {
+		base.WaitTimeout = override.WaitTimeout
+	}
+	if len(override.Caches) > 0 {
+		base.Caches = override.Caches
+	}
+	if override.CacheScope != "" {
+		base.CacheScope = override.CacheScope
+	}
+	if override.PersistHome != nil {
+		base.PersistHome = override.PersistHome
+	}
+	if override.SSHAgent != nil {
+		base.SSHAgent = override.SSHAgent
+	}
+	if override.GitConfig != nil {
+		base.GitConfig = override.GitConfig
+	}
+	if override.Dotfiles != nil {
+		base.Dotfiles = override.Dotfiles
+	}
+	if override.Compose != nil {
+		base.Compose = override.Compose
+	}
+	if len(override.Services) > 0 {
+		// services are merged by name, a service replaces the one with the
+		// same name from the lower-priority source
+		services := maps.Clone(base.Services)
+		if services == nil {
+			services = make(map[string]ServiceConfig, len(override.Services))
+		}
+		maps.Copy(services, override.Services)
+		base.Services = services
+	}
+	if override.Profile != "" {
+		base.Profile = override.Profile
+	}
+	if len(override.Profiles) > 0 {
+		// profiles are merged by name, a profile replaces the one with the
+		// same name from the lower-priority source
+		profiles := maps.Clone(base.Profiles)
+		if profiles == nil {
+			profiles = make(map[string]ConfigValues, len(override.Profiles))
+		}
+		maps.Copy(profiles, override.Profiles)
+		base.Profiles = profiles
+	}
 	return base
 }
 
+// configMergedKeys lists the keys whose values mergeConfig merges by name
+// rather than replaces, so that they may come from several sources.
+var configMergedKeys = []string{"env", "services", "profiles"}
+
+// configProfile returns the values of the named profile.
+func configProfile(profiles map[string]ConfigValues, name string) ConfigValues {
+	profile, ok := profiles[name]
+	if !ok {
+		available := slices.Sorted(maps.Keys(profiles))
+		if len(available) == 0 {
+			log.Fatalf("ERROR: Profile %s is not defined, the config has no profiles", name)
+		}
+		log.Fatalf("ERROR: Profile %s is not defined, available profiles: %s", name, strings.Join(available, ", "))
+	}
+
+	// profiles cannot be nested
+	profile.Profile = ""
+	profile.Profiles = nil
+
+	return profile
+}
+
 // configLoadFlags collects values provided via command-line flags. Only flags
 // that were explicitly set on the command line are taken into account, so that
 // unset flags do not clobber values coming from the config files.
@@ -181,6 +495,12 @@ func configLoadFlags(cmd *cobra.Command) ConfigValues {
 	if flags.Changed("image") {
 		cfg.Image, _ = flags.GetString("image")
 	}
+	if flags.Changed("pull") {
+		cfg.Pull, _ = flags.GetString("pull")
+	}
+	if flags.Changed("snapshot") {
+		cfg.Snapshot, _ = flags.GetString("snapshot")
+	}
 	if flags.Changed("name") {
 		cfg.Name, _ = flags.GetString("name")
 	}
@@ -208,18 +528,156 @@ func configLoadFlags(cmd *cobra.Command) ConfigValues {
 	if flags.Changed("dns") {
 		cfg.DNS, _ = flags.GetString("dns")
 	}
+	if flags.Changed("env") {
+		env, _ := flags.GetStringArray("env")
+		cfg.Env = make(map[string]string, len(env))
+		for _, e := range env {
+			name, value, ok := strings.Cut(e, "=")
+			if !ok {
+				// like docker, pass the value of the variable on the host
+				value = interpolateEscape(os.Getenv(name))
+			}
+			cfg.Env[name] = value
+		}
+	}
+	if flags.Changed("user") {
+		cfg.User, _ = flags.GetString("user")
+	}
+	if flags.Changed("post-create-cmd") {
+		cfg.PostCreateCmd, _ = flags.GetString("post-create-cmd")
+	}
+	if flags.Changed("wait-timeout") {
+		cfg.WaitTimeout, _ = flags.GetString("wait-timeout")
+	}
+	if flags.Changed("caches") {
+		cfg.Caches, _ = flags.GetStringSlice("caches")
+	}
+	if flags.Changed("cache-scope") {
+		cfg.CacheScope, _ = flags.GetString("cache-scope")
+	}
+	if flags.Changed("persist-home") {
+		persistHome, _ := flags.GetBool("persist-home")
+		cfg.PersistHome = &persistHome
+	}
+	if flags.Changed("ssh-agent") {
+		sshAgent, _ := flags.GetBool("ssh-agent")
+		cfg.SSHAgent = &sshAgent
+	}
+	if flags.Changed("git-config") {
+		gitConfig, _ := flags.GetBool("git-config")
+		cfg.GitConfig = &gitConfig
+	}
+	if flags.Changed("profile") {
+		cfg.Profile, _ = flags.GetString("profile")
+	}
 
 	return cfg
 }
 
+// configEnvKeys lists the keys that cannot be set via environment variables,
+// as they only make sense in config files.
+var configEnvKeys = []string{"extends", "build", "healthcheck", "dotfiles", "services", "compose", "profiles"}
+
+// configLoadEnv collects values provided via environment variables. Every key
+// is read from a DEVSH_<KEY> variable named after the upper-cased yaml key,
+// e.g. DEVSH_SHELL_CMD. List values are separated by commas, map values are
+// given as comma-separated KEY=VALUE pairs, and boolean values as true/false
+// (or 1/0). A comma within a value is escaped as \,. Empty variables are
+// ignored.
+func configLoadEnv() ConfigValues {
+	var cfg ConfigValues
+
+	v := reflect.ValueOf(&cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if slices.Contains(configEnvKeys, key) {
+			continue
+		}
+		name := configEnvName(key)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Interface().(type) {
+		case string:
+			field.SetString(value)
+		case []string:
+			var items []string
+			for _, item := range configEnvSplit(value) {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+		case map[string]string:
+			entries := map[string]string{}
+			for _, entry := range configEnvSplit(value) {
+				k, v, ok := strings.Cut(entry, "=")
+				if !ok {
+					log.Fatalf("ERROR: Invalid value of %s, expected comma-separated KEY=VALUE pairs: %s", name, value)
+				}
+				entries[strings.TrimSpace(k)] = v
+			}
+			field.Set(reflect.ValueOf(entries))
+		case *bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				log.Fatalf("ERROR: Invalid value of %s, expected true or false: %s", name, value)
+			}
+			field.Set(reflect.ValueOf(&b))
+		}
+	}
+
+	return cfg
+}
+
+// configEnvSplit splits the value of an environment variable at commas. A
+// comma that is part of an item is escaped as \,.
+func configEnvSplit(value string) []string {
+	var items []string
+	var item strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value) && value[i+1] == ',':
+			item.WriteByte(',')
+			i++
+		case value[i] == ',':
+			items = append(items, item.String())
+			item.Reset()
+		default:
+			item.WriteByte(value[i])
+		}
+	}
+	return append(items, item.String())
+}
+
+// configEnvName returns the name of the environment variable for a config key,
+// e.g. DEVSH_SHELL_CMD for shell_cmd.
+func configEnvName(key string) string {
+	return "DEVSH_" + strings.ToUpper(key)
+}
+
 // configGlobalPath returns the path to the global configuration file. The
 // location can be overridden with the DEVSH_CONFIG environment variable; it
-// defaults to ~/.config/devsh. A leading '~' is expanded to the user's home
-// directory.
+// defaults to ~/.config/devsh, or ~/.config/devsh/config when ~/.config/devsh
+// is a folder (which can hold other user files, e.g. templates). A leading
+// '~' is expanded to the user's home directory.
 func configGlobalPath() string {
 	if p := os.Getenv("DEVSH_CONFIG"); p != "" {
 		return expandTilde(p)
 	}
+	path := configUserDir()
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return filepath.Join(path, "config")
+	}
+	return path
+}
+
+// configUserDir returns the location of the user's devsh files, ~/.config/devsh.
+func configUserDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatalf("ERROR: Failed to determine home directory: %s", err)
@@ -227,6 +685,20 @@ func configGlobalPath() string {
 	return filepath.Join(home, ".config", "devsh")
 }
 
+// configStateDir returns the folder where devsh keeps its state, e.g. the
+// registry of open sessions: $XDG_STATE_HOME/devsh, or ~/.local/state/devsh
+// by default.
+func configStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "devsh")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatalf("ERROR: Failed to determine home directory: %s", err)
+	}
+	return filepath.Join(home, ".local", "state", "devsh")
+}
+
 // expandTilde replaces a leading '~' with the user's home directory.
 func expandTilde(p string) string {
 	if p == "~" {
@@ -249,25 +721,36 @@ func expandTilde(p string) string {
 func configLoadLocal() ConfigValues {
 	// A project config file is optional; return an empty configuration when it
 	// is not present (e.g. when running with minimal configuration).
-	_, err := os.Stat(configFilename)
-	if errors.Is(err, os.ErrNotExist) {
+	path := configLocalPath()
+	if path == "" {
 		return ConfigValues{}
 	}
-	if err != nil {
-		log.Fatalf("ERROR: Failed to stat config file %s: %s", configFilename, err)
+	if path != configFilename {
+		return configLoadFile(path, "devcontainer config file", nil)
 	}
 
-	configFile, err := os.ReadFile(configFilename)
-	if err != nil {
-		log.Fatalf("ERROR: Failed to read config file %s: %s", configFilename, err)
+	return configLoadFile(configFilename, "config file", nil)
+}
+
+// configLocalPath returns the path to the project config file: the .devsh
+// file, or a devcontainer.json if the project has no .devsh file. It returns
+// an empty string if the project has neither.
+func configLocalPath() string {
+	_, err := os.Stat(configFilename)
+	if err == nil {
+		return configFilename
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("ERROR: Failed to stat config file %s: %s", configFilename, err)
 	}
 
-	var configValues ConfigValues
-	if err := yaml.Unmarshal(configFile, &configValues); err != nil {
-		log.Fatalf("ERROR: Failed to parse config file %s: %s", configFilename, err)
+	for _, path := range devcontainerFilenames {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
 	}
 
-	return configValues
+	return ""
 }
 
 func configLoadGlobal() ConfigValues {
@@ -283,17 +766,106 @@ func configLoadGlobal() ConfigValues {
 		log.Fatalf("ERROR: Failed to stat global config file %s: %s", path, err)
 	}
 
+	return configLoadFile(path, "global config file", nil)
+}
+
+// configLoadFile reads and parses the config file at path. desc describes the
+// file in error messages, e.g. "global config file".
+//
+// If the file extends a base config file, the base is loaded recursively and
+// the values of the file are merged on top of it. extendedBy lists the
+// absolute paths of the files that (transitively) extend this one and is used
+// to detect cycles.
+func configLoadFile(path string, desc string, extendedBy []string) ConfigValues {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to resolve path of %s %s: %s", desc, path, err)
+	}
+	if slices.Contains(extendedBy, absPath) {
+		log.Fatalf("ERROR: Config files extend each other in a cycle: %s", strings.Join(append(extendedBy, absPath), " -> "))
+	}
+
 	configFile, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("ERROR: Failed to read global config file %s: %s", path, err)
+		log.Fatalf("ERROR: Failed to read %s %s: %s", desc, path, err)
+	}
+
+	// a devcontainer.json is translated, it cannot extend other files
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		configValues, err := devcontainerParse(configFile, absPath)
+		if err != nil {
+			log.Fatalf("ERROR: Failed to parse %s %s: %s", desc, path, err)
+		}
+		return configValues
 	}
 
 	var configValues ConfigValues
 	if err := yaml.Unmarshal(configFile, &configValues); err != nil {
-		log.Fatalf("ERROR: Failed to parse global config file %s: %s", path, err)
+		log.Fatalf("ERROR: Failed to parse %s %s: %s", desc, path, err)
+	}
+
+	// relative dotfiles are resolved against the folder of the file
+	if d := configValues.Dotfiles; d != nil && d.Path != "" && !filepath.IsAbs(d.Path) && !strings.HasPrefix(d.Path, "~") && !strings.HasPrefix(d.Path, "$") {
+		dotfiles := *d
+		dotfiles.Path = filepath.Join(interpolateEscape(filepath.Dir(absPath)), d.Path)
+		configValues.Dotfiles = &dotfiles
+	}
+
+	// the values translated from a compose file are overridden by the ones
+	// of the file that refers to it
+	if configValues.Compose != nil {
+		composed, err := composeLoad(configValues.Compose, filepath.Dir(absPath))
+		if err != nil {
+			log.Fatalf("ERROR: Failed to load the compose file of %s %s: %s", desc, path, err)
+		}
+		configValues = mergeConfig(composed, configValues)
+	}
+
+	if configValues.Extends == "" {
+		return configValues
 	}
 
-	return configValues
+	basePath, err := configExtendsPath(configValues.Extends, filepath.Dir(absPath))
+	if err != nil {
+		log.Fatalf("ERROR: Invalid extends in %s %s: %s", desc, path, err)
+	}
+	base := configLoadFile(basePath, "base config file", append(extendedBy, absPath))
+	configValues.Extends = ""
+
+	return mergeConfig(base, configValues)
+}
+
+// configExtendsPath resolves the reference to a base config file given in the
+// extends key. The reference is either a local path or a file:// URL and may
+// refer to environment variables (e.g. ${TEAM_DIR}/devsh/go.yaml). A leading
+// '~' is expanded to the user's home directory, and relative paths are
+// resolved against dir, the folder of the file that extends the base.
+func configExtendsPath(ref string, dir string) (string, error) {
+	ref, err := interpolateString(ref, configVarsLookup(nil))
+	if err != nil {
+		return "", err
+	}
+
+	if strings.Contains(ref, "://") {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "file" {
+			return "", fmt.Errorf("unsupported URL scheme %s, only local files can be extended", u.Scheme)
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			return "", fmt.Errorf("file URL %s does not refer to a local file", ref)
+		}
+		ref = u.Path
+	}
+
+	ref = expandTilde(ref)
+	if !filepath.IsAbs(ref) {
+		ref = filepath.Join(dir, ref)
+	}
+
+	return ref, nil
 }
 
 // Returns the project folder (i.e. current folder) on the host
@@ -324,9 +896,15 @@ func configDefaultContainerDir(configValues ConfigValues) string {
 // Returns the default name for the dev container. The name is composed of the
 // project (directory) name and a short suffix derived from the full path to the
 // project directory, e.g. "devsh-a1b2". The suffix disambiguates containers
-// for projects that share the same directory name.
+// for projects that share the same directory name. When a profile is selected,
+// its name is appended, e.g. "devsh-a1b2-slim", so that the containers of
+// different profiles can run at the same time.
 func configDefaultContainerName(configValues ConfigValues) string {
-	return configValues.Name + "-" + configProjectPathHash()
+	name := configValues.Name + "-" + configProjectPathHash()
+	if configValues.Profile != "" {
+		name += "-" + configValues.Profile
+	}
+	return name
 }
 
 // configProjectPathHash returns the first 4 hex characters of the SHA-256 hash
@@ -336,8 +914,17 @@ func configProjectPathHash() string {
 	return hex.EncodeToString(h[:2])
 }
 
-// Returns the default network for the dev container
-func configDefaultNetwork(_configValues ConfigValues) string {
+// Returns the default tag for the image built for the dev container
+func configDefaultImage(configValues ConfigValues) string {
+	return "devsh-" + strings.ToLower(configValues.ContainerName)
+}
+
+// Returns the default network for the dev container: the network of the
+// project when the dev container has services, so that it can reach them
+func configDefaultNetwork(configValues ConfigValues) string {
+	if len(configValues.Services) > 0 {
+		return networkProject
+	}
 	return ""
 }
 
@@ -346,6 +933,74 @@ func configDefaultDNS(_configValues ConfigValues) string {
 	return ""
 }
 
+// configExplain prints every value of the effective config along with the
+// source it comes from. Values that are not provided by any source are
+// derived defaults; env entries may come from several sources, as they are
+// merged by name.
+func configExplain(cfg ConfigValues, layers []configLayer) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.IsZero() {
+			continue
+		}
+
+		var sources []string
+		for _, layer := range slices.Backward(layers) {
+			if reflect.ValueOf(layer.values).Field(i).IsZero() {
+				continue
+			}
+			sources = append(sources, layer.source)
+			if !slices.Contains(configMergedKeys, yamlKey(t.Field(i))) {
+				break
+			}
+		}
+		if len(sources) == 0 {
+			sources = append(sources, "derived default")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", yamlKey(t.Field(i)), configExplainValue(field.Interface()), strings.Join(sources, ", "))
+	}
+
+	w.Flush()
+}
+
+// configExplainValue formats a config value on a single line.
+func configExplainValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	node.Style = yaml.FlowStyle
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// configBool returns the value of an optional boolean config value, which is
+// false when it is not set.
+func configBool(value *bool) bool {
+	return value != nil && *value
+}
+
+// configReportWarnings prints the warnings collected while loading the config.
+func configReportWarnings() {
+	for _, w := range configWarnings {
+		log.Printf("WARN: %s", w)
+	}
+}
+
 // Returns the primary volume (mounting the project folder) for the dev container
 // Example:
 //
@@ -356,4 +1011,6 @@ func configDevContainerPrimaryVolume(configValues ConfigValues) string {
 
 func init() {
 	rootCmd.AddCommand(configCmd)
+
+	configCmd.Flags().Bool("explain", false, "Show the source of every configuration value")
 }
@@ -0,0 +1,619 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// configGetCmd represents the config get command
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print a value from the config file",
+	Long: `Print the value of a key as it is set in the project config file (.devsh),
+or in the global config file with --global.
+
+Nested keys are separated by dots, e.g. env.GOFLAGS, build.dockerfile or
+profiles.full.image. List values are printed one item per line.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := configEditKey(args[0])
+		path, doc := configEditLoad(cmd)
+
+		node := configEditFind(doc.Content[0], key)
+		if node == nil {
+			log.Fatalf("ERROR: Key %s is not set in %s", args[0], path)
+		}
+
+		switch node.Kind {
+		case yaml.ScalarNode:
+			fmt.Println(node.Value)
+		case yaml.SequenceNode:
+			for _, item := range node.Content {
+				fmt.Println(item.Value)
+			}
+		default:
+			out, err := yaml.Marshal(node)
+			if err != nil {
+				log.Fatalf("ERROR: Failed to serialize %s: %s", args[0], err)
+			}
+			fmt.Print(string(out))
+		}
+	},
+}
+
+// configSetCmd represents the config set command
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>...",
+	Short: "Set a value in the config file",
+	Long: `Set the value of a key in the project config file (.devsh), or in the global
+config file with --global. The file is created if it does not exist. Only the
+lines of the edited key are rewritten, the rest of the file is kept as is.
+
+List keys (e.g. ports or volumes) take one or more values, which replace the
+whole list. Use "devsh config add" and "devsh config remove" to change single
+items instead.
+
+For example:
+	devsh config set image dev-go
+	devsh config set env.EDITOR vim
+	devsh config set -- env.GOFLAGS -mod=mod # values starting with "-" follow "--"
+	devsh config set ports 8080:8080 9090:9090
+	devsh config set --global shell_cmd /bin/zsh
+`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := configEditKey(args[0])
+		values := args[1:]
+		path, doc := configEditLoad(cmd)
+
+		var value *yaml.Node
+		switch configEditKind(key) {
+		case reflect.String:
+			if len(values) != 1 {
+				log.Fatalf("ERROR: Key %s takes a single value", args[0])
+			}
+			value = configEditScalar(values[0])
+		case reflect.Bool:
+			b, err := strconv.ParseBool(values[0])
+			if err != nil || len(values) != 1 {
+				log.Fatalf("ERROR: Key %s takes a single value, true or false", args[0])
+			}
+			value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(b)}
+		case reflect.Int:
+			n, err := strconv.Atoi(values[0])
+			if err != nil || len(values) != 1 {
+				log.Fatalf("ERROR: Key %s takes a single number", args[0])
+			}
+			value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(n)}
+		case reflect.Slice:
+			value = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			// keep the style of the existing list, e.g. [a, b]
+			if existing := configEditFind(doc.Content[0], key); existing != nil && existing.Kind == yaml.SequenceNode {
+				value.Style = existing.Style
+			}
+			for _, v := range values {
+				value.Content = append(value.Content, configEditScalar(v))
+			}
+		default:
+			log.Fatalf("ERROR: Key %s cannot be set as a whole, set its nested keys instead", args[0])
+		}
+
+		configEditSet(doc.Content[0], key, value)
+		configEditSave(path, doc, key)
+	},
+}
+
+// configUnsetCmd represents the config unset command
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Remove a value from the config file",
+	Long: `Remove a key from the project config file (.devsh), or from the global config
+file with --global, so that its value is inherited from a lower-priority source.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := configEditKey(args[0])
+		path, doc := configEditLoad(cmd)
+
+		if !configEditUnset(doc.Content[0], key) {
+			log.Fatalf("ERROR: Key %s is not set in %s", args[0], path)
+		}
+		configEditSave(path, doc, key)
+	},
+}
+
+// configAddCmd represents the config add command
+var configAddCmd = &cobra.Command{
+	Use:   "add <key> <value>...",
+	Short: "Add items to a list in the config file",
+	Long: `Add items to a list key (e.g. ports or volumes) in the project config file
+(.devsh), or in the global config file with --global. Items that are already
+in the list are not added again.
+
+For example:
+	devsh config add ports 8080:8080
+	devsh config add --global volumes ~/.ssh:/root/.ssh:ro
+`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := configEditKey(args[0])
+		if configEditKind(key) != reflect.Slice {
+			log.Fatalf("ERROR: Key %s is not a list", args[0])
+		}
+		path, doc := configEditLoad(cmd)
+
+		list := configEditFind(doc.Content[0], key)
+		if list == nil || list.Kind != yaml.SequenceNode {
+			list = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			configEditSet(doc.Content[0], key, list)
+		}
+		for _, v := range args[1:] {
+			if !slices.ContainsFunc(list.Content, func(item *yaml.Node) bool { return item.Value == v }) {
+				list.Content = append(list.Content, configEditScalar(v))
+			}
+		}
+
+		configEditSave(path, doc, key)
+	},
+}
+
+// configRemoveCmd represents the config remove command
+var configRemoveCmd = &cobra.Command{
+	Use:   "remove <key> <value>...",
+	Short: "Remove items from a list in the config file",
+	Long: `Remove items from a list key (e.g. ports or volumes) in the project config
+file (.devsh), or in the global config file with --global. The key is removed
+altogether when the list becomes empty.
+`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := configEditKey(args[0])
+		if configEditKind(key) != reflect.Slice {
+			log.Fatalf("ERROR: Key %s is not a list", args[0])
+		}
+		path, doc := configEditLoad(cmd)
+
+		list := configEditFind(doc.Content[0], key)
+		if list == nil || list.Kind != yaml.SequenceNode {
+			log.Fatalf("ERROR: Key %s is not set in %s", args[0], path)
+		}
+		for _, v := range args[1:] {
+			i := slices.IndexFunc(list.Content, func(item *yaml.Node) bool { return item.Value == v })
+			if i < 0 {
+				log.Fatalf("ERROR: %s is not in %s", v, args[0])
+			}
+			list.Content = slices.Delete(list.Content, i, i+1)
+		}
+		if len(list.Content) == 0 {
+			configEditUnset(doc.Content[0], key)
+		}
+
+		configEditSave(path, doc, key)
+	},
+}
+
+// configEditKey splits a dotted key into its parts and validates it against
+// the structure of ConfigValues.
+func configEditKey(key string) []string {
+	parts := strings.Split(key, ".")
+	if _, err := configEditType(parts); err != nil {
+		log.Fatalf("ERROR: Invalid config key %s: %s", key, err)
+	}
+	return parts
+}
+
+// configEditKind returns the kind of the value of a (valid) key.
+func configEditKind(key []string) reflect.Kind {
+	t, _ := configEditType(key)
+	return t.Kind()
+}
+
+// configEditType returns the type of the config value a key refers to.
+func configEditType(key []string) (reflect.Type, error) {
+	t := reflect.TypeOf(ConfigValues{})
+	for i, part := range key {
+		if part == "" {
+			return nil, errors.New("empty key")
+		}
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := configEditField(t, part)
+			if !ok {
+				return nil, fmt.Errorf("unknown key %s", strings.Join(key[:i+1], "."))
+			}
+			t = field.Type
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return nil, fmt.Errorf("%s has no nested keys", strings.Join(key[:i], "."))
+		}
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t, nil
+}
+
+// configEditField returns the field of struct type t with the given yaml key.
+func configEditField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if yamlKey(t.Field(i)) == key {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// configEditPath returns the config file edited by the config subcommands:
+// the global config file with --global, otherwise the project .devsh file. A
+// project configured by a devcontainer.json is not edited, as a new .devsh
+// file would silently replace it.
+func configEditPath(cmd *cobra.Command) string {
+	if global, _ := cmd.Flags().GetBool("global"); global {
+		return configGlobalPath()
+	}
+	if path := configLocalPath(); path != "" && path != configFilename {
+		log.Fatalf("ERROR: The project is configured by %s, which devsh does not edit; edit it directly, or create %s with `extends: %s` to override its values",
+			path, configFilename, path)
+	}
+	return configFilename
+}
+
+// configEditLoad parses the config file edited by the command into a yaml
+// document node. A missing or empty file results in an empty document.
+func configEditLoad(cmd *cobra.Command) (string, *yaml.Node) {
+	path := configEditPath(cmd)
+
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("ERROR: Failed to read config file %s: %s", path, err)
+	}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		log.Fatalf("ERROR: Failed to parse config file %s: %s", path, err)
+	}
+
+	if len(doc.Content) == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		log.Fatalf("ERROR: Config file %s is not a YAML mapping", path)
+	}
+
+	return path, doc
+}
+
+// configEditSave writes the edited document back to the config file. The
+// document is validated against ConfigValues before it is written.
+//
+// To keep the comments and formatting of the file, only the entry of the
+// edited key is rewritten (see configEditSplice). If that is not possible, the
+// whole document is written with the indentation of the file, which keeps the
+// comments but not blank lines and alignment.
+func configEditSave(path string, doc *yaml.Node, key []string) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("ERROR: Failed to read config file %s: %s", path, err)
+	}
+
+	var orig yaml.Node
+	if err := yaml.Unmarshal(data, &orig); err != nil {
+		log.Fatalf("ERROR: Failed to parse config file %s: %s", path, err)
+	}
+	indent := configEditIndent(&orig)
+
+	out, err := configEditEncode(doc, indent)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to serialize config file %s: %s", path, err)
+	}
+	if spliced, ok := configEditSplice(data, &orig, doc, key, indent); ok && configEditSame(spliced, out) {
+		out = spliced
+	}
+
+	var configValues ConfigValues
+	if err := yaml.Unmarshal(out, &configValues); err != nil {
+		log.Fatalf("ERROR: Resulting config file %s would be invalid: %s", path, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.WriteFile(path, out, 0o644)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Failed to write config file %s: %s", path, err)
+	}
+}
+
+// configEditEncode serializes a yaml node with the given indentation.
+func configEditEncode(node *yaml.Node, indent int) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(indent)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// configEditSame returns true if two yaml documents hold the same data.
+func configEditSame(a, b []byte) bool {
+	var va, vb any
+	if yaml.Unmarshal(a, &va) != nil || yaml.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// configEditIndent returns the indentation of the nested mappings in a
+// document, 2 if it has none.
+func configEditIndent(node *yaml.Node) int {
+	if node.Kind == yaml.MappingNode && node.Style&yaml.FlowStyle == 0 {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			if v.Kind == yaml.MappingNode && v.Style&yaml.FlowStyle == 0 && len(v.Content) > 0 && v.Content[0].Column > k.Column {
+				return v.Content[0].Column - k.Column
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if indent := configEditIndent(child); indent != 2 {
+			return indent
+		}
+	}
+	return 2
+}
+
+// configEditSplice applies an edit of key to the text of a config file by
+// rewriting only the affected entry: the entry of the key (or of the mapping
+// that becomes empty) is replaced or removed, and a new entry is inserted at
+// the end of its mapping. orig is the original document, doc the edited one.
+// Entries within flow collections (e.g. env: {A: 1}) are rewritten along with
+// the whole collection. It returns false if the file cannot be edited in
+// place, e.g. when it is empty.
+func configEditSplice(data []byte, orig *yaml.Node, doc *yaml.Node, key []string, indent int) ([]byte, bool) {
+	if len(orig.Content) == 0 || orig.Content[0].Kind != yaml.MappingNode || orig.Content[0].Style&yaml.FlowStyle != 0 {
+		return nil, false
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	root, edited := orig.Content[0], doc.Content[0]
+
+	// the entries of the key and its parents that are in block mappings
+	var entries []*yaml.Node // key and value nodes
+	node := root
+	for _, part := range key {
+		if node.Kind != yaml.MappingNode || node.Style&yaml.FlowStyle != 0 {
+			break
+		}
+		i := configEditIndex(node, part)
+		if i < 0 {
+			break
+		}
+		entries = append(entries, node.Content[i], node.Content[i+1])
+		node = node.Content[i+1]
+	}
+	depth := len(entries) / 2
+
+	join := func(parts ...[]string) ([]byte, bool) {
+		return []byte(strings.Join(slices.Concat(parts...), "\n") + "\n"), true
+	}
+
+	// the entry was removed, or its mapping became empty
+	for d := 0; d < depth; d++ {
+		if configEditFind(edited, key[:d+1]) != nil {
+			continue
+		}
+		k := entries[2*d]
+		start, end := configEditRegion(lines, k, entries[2*d+1])
+		// along with the comments right above it
+		for start > 0 && configEditIsComment(lines[start-1]) && configEditIndentOf(lines[start-1]) == k.Column-1 {
+			start--
+		}
+		return join(lines[:start], lines[end:])
+	}
+
+	// the value of the entry changed
+	if depth > 0 {
+		k, v := entries[2*depth-2], entries[2*depth-1]
+		if depth == len(key) || v.Kind != yaml.MappingNode || v.Style&yaml.FlowStyle != 0 {
+			value := configEditFind(edited, key[:depth])
+			entry, err := configEditEncodeEntry(k, value, k.Column-1, indent)
+			if err != nil {
+				return nil, false
+			}
+			start, end := configEditRegion(lines, k, v)
+			// keep the items of a list at the column of its key
+			if value.Kind == yaml.SequenceNode && value.Style&yaml.FlowStyle == 0 && v.Kind == yaml.SequenceNode &&
+				start+1 < end && configEditIndentOf(lines[start+1]) == k.Column-1 {
+				for i := 1; i < len(entry); i++ {
+					entry[i] = strings.TrimPrefix(entry[i], strings.Repeat(" ", indent))
+				}
+			}
+			return join(lines[:start], entry, lines[end:])
+		}
+	}
+
+	// a new entry is added to the end of a mapping
+	at, column := len(lines), 0
+	if depth > 0 {
+		k, v := entries[2*depth-2], entries[2*depth-1]
+		_, at = configEditRegion(lines, k, v)
+		column = v.Content[0].Column - 1
+	} else {
+		for at > 0 && strings.TrimSpace(lines[at-1]) == "" {
+			at--
+		}
+		column = root.Content[0].Column - 1
+	}
+	name := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key[depth]}
+	entry, err := configEditEncodeEntry(name, configEditFind(edited, key[:depth+1]), column, indent)
+	if err != nil {
+		return nil, false
+	}
+	return join(lines[:at], entry, lines[at:])
+}
+
+// configEditRegion returns the range of lines taken by an entry of a block
+// mapping, from the line of its key up to the last line of its value.
+func configEditRegion(lines []string, key *yaml.Node, value *yaml.Node) (int, int) {
+	start, column := key.Line-1, key.Column-1
+	inside := func(line string) bool {
+		trimmed := strings.TrimSpace(line)
+		indent := configEditIndentOf(line)
+		// items of a list may be at the column of its key
+		inList := value.Kind == yaml.SequenceNode && indent == column && strings.HasPrefix(trimmed, "-")
+		return indent > column || inList
+	}
+
+	end := start + 1
+	for i := start + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" || configEditIsComment(lines[i]) {
+			// blank lines and comments belong to the entry if it continues
+			// after them
+			continue
+		}
+		if !inside(lines[i]) {
+			break
+		}
+		end = i + 1
+	}
+	return start, end
+}
+
+// configEditEncodeEntry serializes a single entry of a mapping, indented by
+// column. The comments above the key are left out, as they are not part of
+// the lines the entry replaces.
+func configEditEncodeEntry(key *yaml.Node, value *yaml.Node, column int, indent int) ([]string, error) {
+	k := &yaml.Node{Kind: yaml.ScalarNode, Tag: key.Tag, Style: key.Style, Value: key.Value, LineComment: key.LineComment}
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{k, value}}
+	out, err := configEditEncode(mapping, indent)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = strings.Repeat(" ", column) + line
+		}
+	}
+	return lines, nil
+}
+
+// configEditIndentOf returns the number of leading spaces of a line.
+func configEditIndentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// configEditIsComment returns true if a line holds only a comment.
+func configEditIsComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// configEditFind returns the value node of a key in a mapping node, or nil if
+// the key is not set.
+func configEditFind(mapping *yaml.Node, key []string) *yaml.Node {
+	node := mapping
+	for _, part := range key {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		i := configEditIndex(node, part)
+		if i < 0 {
+			return nil
+		}
+		node = node.Content[i+1]
+	}
+	return node
+}
+
+// configEditSet sets the value node of a key in a mapping node, creating the
+// intermediate mappings as needed. The comments of an existing value are kept.
+func configEditSet(mapping *yaml.Node, key []string, value *yaml.Node) {
+	node := mapping
+	for _, part := range key[:len(key)-1] {
+		i := configEditIndex(node, part)
+		if i < 0 || node.Content[i+1].Kind != yaml.MappingNode {
+			child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			configEditSet(node, []string{part}, child)
+			node = child
+			continue
+		}
+		node = node.Content[i+1]
+	}
+
+	last := key[len(key)-1]
+	i := configEditIndex(node, last)
+	if i < 0 {
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last}, value)
+		return
+	}
+	old := node.Content[i+1]
+	value.HeadComment, value.LineComment, value.FootComment = old.HeadComment, old.LineComment, old.FootComment
+	node.Content[i+1] = value
+}
+
+// configEditUnset removes a key from a mapping node along with the mappings
+// that become empty as a result. It returns false if the key is not set.
+func configEditUnset(mapping *yaml.Node, key []string) bool {
+	if mapping.Kind != yaml.MappingNode {
+		return false
+	}
+	i := configEditIndex(mapping, key[0])
+	if i < 0 {
+		return false
+	}
+	if len(key) > 1 {
+		child := mapping.Content[i+1]
+		if !configEditUnset(child, key[1:]) {
+			return false
+		}
+		if len(child.Content) > 0 {
+			return true
+		}
+	}
+	mapping.Content = slices.Delete(mapping.Content, i, i+2)
+	return true
+}
+
+// configEditIndex returns the index of the key node with the given name in a
+// mapping node, or -1 if there is no such key.
+func configEditIndex(mapping *yaml.Node, name string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// configEditScalar returns a string scalar node for value.
+func configEditScalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func init() {
+	for _, c := range []*cobra.Command{configGetCmd, configSetCmd, configUnsetCmd, configAddCmd, configRemoveCmd} {
+		configCmd.AddCommand(c)
+		c.Flags().Bool("global", false, "Use the global config file instead of the project .devsh file")
+	}
+}
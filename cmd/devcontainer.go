@@ -0,0 +1,342 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// devcontainerFilenames lists the locations of a devcontainer.json that are
+// used when the project has no .devsh file, in order of preference.
+var devcontainerFilenames = []string{
+	filepath.Join(".devcontainer", "devcontainer.json"),
+	".devcontainer.json",
+}
+
+// devcontainerConfig holds the properties of a devcontainer.json that devsh
+// translates into its own config.
+type devcontainerConfig struct {
+	Image string `json:"image"`
+	Build *struct {
+		Dockerfile string            `json:"dockerfile"`
+		Context    string            `json:"context"`
+		Args       map[string]string `json:"args"`
+		Target     string            `json:"target"`
+	} `json:"build"`
+	DockerFile        string            `json:"dockerFile"` // deprecated form of build.dockerfile
+	Context           string            `json:"context"`    // deprecated form of build.context
+	ForwardPorts      []any             `json:"forwardPorts"`
+	Mounts            []any             `json:"mounts"`
+	ContainerEnv      map[string]string `json:"containerEnv"`
+	RemoteUser        string            `json:"remoteUser"`
+	WorkspaceFolder   string            `json:"workspaceFolder"`
+	PostCreateCommand any               `json:"postCreateCommand"`
+}
+
+// devcontainerProperties lists the top-level properties of a devcontainer.json
+// that are either translated or have no effect on the dev container, so they
+// are not reported as ignored.
+var devcontainerProperties = []string{
+	"$schema", "name",
+	"image", "build", "dockerFile", "context", "forwardPorts", "mounts",
+	"containerEnv", "remoteUser", "workspaceFolder", "postCreateCommand",
+}
+
+// devcontainerVarRe matches the variables of the devcontainer.json format,
+// e.g. ${localWorkspaceFolder} or ${localEnv:HOME:/root}.
+var devcontainerVarRe = regexp.MustCompile(`\$\{(localEnv|containerEnv|localWorkspaceFolder|localWorkspaceFolderBasename|containerWorkspaceFolder|containerWorkspaceFolderBasename)(?::([^}:]*))?(?::([^}]*))?\}`)
+
+// devcontainerParse translates the contents of a devcontainer.json into the
+// devsh config. path is the absolute path of the file; relative paths in the
+// build properties are resolved against its folder. Properties that cannot be
+// translated are reported as config warnings.
+func devcontainerParse(data []byte, path string) (ConfigValues, error) {
+	var cfg ConfigValues
+
+	data = jsoncStrip(data)
+
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(data, &properties); err != nil {
+		return cfg, err
+	}
+	var dc devcontainerConfig
+	if err := json.Unmarshal(data, &dc); err != nil {
+		return cfg, err
+	}
+
+	var ignored []string
+	for _, name := range slices.Sorted(maps.Keys(properties)) {
+		if !slices.Contains(devcontainerProperties, name) {
+			ignored = append(ignored, name)
+		}
+	}
+	if len(ignored) > 0 {
+		configWarnings = append(configWarnings, fmt.Sprintf("%s: ignoring unsupported properties: %s", path, strings.Join(ignored, ", ")))
+	}
+
+	dir := filepath.Dir(path)
+	vars := func(s string) string {
+		return devcontainerVars(s, dc.WorkspaceFolder)
+	}
+
+	cfg.Image = vars(dc.Image)
+	cfg.ContainerDir = vars(dc.WorkspaceFolder)
+	cfg.User = vars(dc.RemoteUser)
+
+	if dc.Build != nil || dc.DockerFile != "" {
+		build := &BuildConfig{
+			Dockerfile: dc.DockerFile,
+			Context:    dc.Context,
+		}
+		if dc.Build != nil {
+			build.Dockerfile = dc.Build.Dockerfile
+			build.Context = dc.Build.Context
+			build.Args = dc.Build.Args
+			build.Target = dc.Build.Target
+		}
+		if build.Context == "" {
+			build.Context = "."
+		}
+		build.Context = filepath.Join(dir, vars(build.Context))
+		if build.Dockerfile != "" {
+			build.Dockerfile = filepath.Join(dir, vars(build.Dockerfile))
+		}
+		for name, value := range build.Args {
+			build.Args[name] = vars(value)
+		}
+		cfg.Build = build
+	}
+
+	for _, port := range dc.ForwardPorts {
+		switch p := port.(type) {
+		case float64:
+			cfg.Ports = append(cfg.Ports, fmt.Sprintf("%d:%d", int(p), int(p)))
+		case string:
+			if strings.Contains(p, ":") {
+				// "host:port" refers to a port of another container
+				configWarnings = append(configWarnings, fmt.Sprintf("%s: ignoring forwarded port %s of another host", path, p))
+				continue
+			}
+			cfg.Ports = append(cfg.Ports, p+":"+p)
+		}
+	}
+
+	for _, mount := range dc.Mounts {
+		volume, err := devcontainerMount(mount)
+		if err != nil {
+			configWarnings = append(configWarnings, fmt.Sprintf("%s: ignoring mount: %s", path, err))
+			continue
+		}
+		cfg.Volumes = append(cfg.Volumes, vars(volume))
+	}
+
+	if len(dc.ContainerEnv) > 0 {
+		cfg.Env = make(map[string]string, len(dc.ContainerEnv))
+		for name, value := range dc.ContainerEnv {
+			cfg.Env[name] = vars(value)
+		}
+	}
+
+	postCreateCmd, err := devcontainerCommand(dc.PostCreateCommand)
+	if err != nil {
+		return cfg, fmt.Errorf("postCreateCommand: %w", err)
+	}
+	cfg.PostCreateCmd = vars(postCreateCmd)
+
+	return cfg, nil
+}
+
+// devcontainerMount translates a mount of a devcontainer.json, given either in
+// the docker --mount format ("source=...,target=...,type=bind") or as an
+// object, into a devsh volume.
+func devcontainerMount(mount any) (string, error) {
+	fields := map[string]string{}
+	switch m := mount.(type) {
+	case string:
+		for _, field := range strings.Split(m, ",") {
+			name, value, _ := strings.Cut(field, "=")
+			fields[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	case map[string]any:
+		for name, value := range m {
+			fields[name] = fmt.Sprint(value)
+		}
+	default:
+		return "", fmt.Errorf("unexpected mount %v", mount)
+	}
+
+	source := cmp.Or(fields["source"], fields["src"])
+	target := cmp.Or(fields["target"], fields["destination"], fields["dst"])
+	if t := fields["type"]; t != "" && t != "bind" && t != "volume" {
+		return "", fmt.Errorf("mount type %s is not supported", t)
+	}
+	if source == "" || target == "" {
+		return "", fmt.Errorf("mount %v has no source or target", mount)
+	}
+
+	volume := source + ":" + target
+	if ro, ok := fields["readonly"]; ok && ro != "false" {
+		volume += ":ro"
+	}
+
+	return volume, nil
+}
+
+// devcontainerCommand translates a lifecycle command of a devcontainer.json
+// into a shell command. The command is given either as a string, as an array
+// of arguments, or as an object of named commands, which are run one after
+// the other.
+func devcontainerCommand(command any) (string, error) {
+	switch c := command.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return c, nil
+	case []any:
+		args := make([]string, 0, len(c))
+		for _, arg := range c {
+			s, ok := arg.(string)
+			if !ok {
+				return "", fmt.Errorf("unexpected argument %v", arg)
+			}
+			args = append(args, shellQuote(s))
+		}
+		return strings.Join(args, " "), nil
+	case map[string]any:
+		var cmds []string
+		for _, name := range slices.Sorted(maps.Keys(c)) {
+			cmd, err := devcontainerCommand(c[name])
+			if err != nil {
+				return "", err
+			}
+			if cmd != "" {
+				cmds = append(cmds, "("+cmd+")")
+			}
+		}
+		return strings.Join(cmds, " && "), nil
+	}
+	return "", fmt.Errorf("unexpected command %v", command)
+}
+
+// devcontainerVars replaces the variables of the devcontainer.json format in s
+// with their devsh equivalents, e.g. ${localEnv:HOME} with ${HOME:-}. Variables
+// referring to the container environment are kept for the shell inside the
+// container. Everything else is escaped, so that e.g. ${VAR} in a command is
+// passed to the shell as is rather than expanded by devsh.
+func devcontainerVars(s string, workspaceFolder string) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range devcontainerVarRe.FindAllStringSubmatchIndex(s, -1) {
+		out.WriteString(interpolateEscape(s[last:loc[0]]))
+		out.WriteString(devcontainerVar(s[loc[0]:loc[1]], workspaceFolder))
+		last = loc[1]
+	}
+	out.WriteString(interpolateEscape(s[last:]))
+	return out.String()
+}
+
+// devcontainerVar translates a single devcontainer.json variable reference.
+func devcontainerVar(ref string, workspaceFolder string) string {
+	m := devcontainerVarRe.FindStringSubmatch(ref)
+	switch m[1] {
+	case "localEnv":
+		// an undefined variable expands to its default, or an empty string
+		return "${" + m[2] + ":-" + interpolateEscape(m[3]) + "}"
+	case "containerEnv":
+		return "$$" + m[2]
+	case "localWorkspaceFolder":
+		return "${project.dir}"
+	case "localWorkspaceFolderBasename":
+		return interpolateEscape(filepath.Base(configProjectDir()))
+	case "containerWorkspaceFolder":
+		if workspaceFolder != "" {
+			return devcontainerVars(workspaceFolder, "")
+		}
+		return "/${project.name}"
+	case "containerWorkspaceFolderBasename":
+		if workspaceFolder != "" {
+			return filepath.Base(devcontainerVars(workspaceFolder, ""))
+		}
+		return "${project.name}"
+	}
+	return interpolateEscape(ref)
+}
+
+// jsoncStrip removes comments and trailing commas from JSON with comments (the
+// format of devcontainer.json), so that it can be parsed as plain JSON.
+func jsoncStrip(data []byte) []byte {
+	var out bytes.Buffer
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out.WriteByte(data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out.WriteByte(c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			out.WriteByte('\n')
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				i = len(data)
+			} else {
+				i += end + 3
+			}
+			out.WriteByte(' ')
+		case c == ',':
+			// drop trailing commas
+			if next := jsoncNext(data, i+1); next == '}' || next == ']' {
+				continue
+			}
+			out.WriteByte(c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
+
+// jsoncNext returns the first character at or after i that is neither
+// whitespace nor part of a comment, or 0 at the end of data.
+func jsoncNext(data []byte, i int) byte {
+	for i < len(data) {
+		switch {
+		case data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n':
+			i++
+		case bytes.HasPrefix(data[i:], []byte("//")):
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				return 0
+			}
+			i += end
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return 0
+			}
+			i += end + 4
+		default:
+			return data[i]
+		}
+	}
+	return 0
+}
@@ -2,15 +2,20 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
 const (
 	dockerCli         = "docker"
 	dockerIdShortSize = 12 // hex characters
+
+	// prefix of the labels devsh puts on the docker objects it manages
+	dockerLabelPrefix = "devsh."
 )
 
 // Constructs a command to a docker and returns it as a string.
@@ -51,19 +56,43 @@ func dockerRunCmd(shellCommand string) string {
 	return strings.TrimSpace(string(out))
 }
 
-// Runs a command in an interactive shell
-func dockerRunInteractive(shellCommand string) {
+// Runs a shell command, streaming its output to the terminal, and returns an
+// error if the command fails
+func dockerRunStreamed(shellCommand string) error {
 	if globalFlagVerbose {
 		fmt.Println("+ " + shellCommand) // if echo/verbose
 	}
 	cmd := exec.Command("/bin/sh", "-c", shellCommand)
-	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run() // add error checking
-	if err != nil {
-		log.Fatalf("WARN: Shell exited with an error: %s", err)
+	return cmd.Run()
+}
+
+// Runs a shell command with its input read from stdin and its output written
+// to stdout (when not nil), and returns an error if the command fails. Errors
+// are streamed to the terminal.
+func dockerRunPiped(shellCommand string, stdin io.Reader, stdout io.Writer) error {
+	if globalFlagVerbose {
+		fmt.Println("+ " + shellCommand) // if echo/verbose
+	}
+	cmd := exec.Command("/bin/sh", "-c", shellCommand)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Runs a command in an interactive shell and returns an error if it exits
+// with an error
+func dockerRunInteractive(shellCommand string) error {
+	if globalFlagVerbose {
+		fmt.Println("+ " + shellCommand) // if echo/verbose
 	}
+	cmd := exec.Command("/bin/sh", "-c", shellCommand)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
 }
 
 // Returns true if the container with given name is present (either running or stopped)
@@ -94,6 +123,46 @@ func dockerIsContainerRunning(name string) bool {
 	}
 }
 
+// Returns true if the image with the given name is present locally
+func dockerIsImagePresent(name string) bool {
+	shellCmd := dockerConstructCmd("image", []string{"inspect"}, shellQuote(name))
+	if globalFlagVerbose {
+		fmt.Println("+ " + shellCmd) // if echo/verbose
+	}
+	_, err := exec.Command("/bin/sh", "-c", shellCmd).Output()
+	return err == nil
+}
+
+// Returns true if the volume with the given name is present
+func dockerIsVolumePresent(name string) bool {
+	shellCmd := dockerConstructCmd("volume", []string{"inspect"}, shellQuote(name))
+	if globalFlagVerbose {
+		fmt.Println("+ " + shellCmd) // if echo/verbose
+	}
+	_, err := exec.Command("/bin/sh", "-c", shellCmd).Output()
+	return err == nil
+}
+
+// Returns true if the network with the given name is present
+func dockerIsNetworkPresent(name string) bool {
+	shellCmd := dockerConstructCmd("network", []string{"inspect"}, shellQuote(name))
+	if globalFlagVerbose {
+		fmt.Println("+ " + shellCmd) // if echo/verbose
+	}
+	_, err := exec.Command("/bin/sh", "-c", shellCmd).Output()
+	return err == nil
+}
+
+// Returns the option that puts a devsh label with the given name and value on
+// a docker object.
+//
+// For example:
+//
+//	dockerLabelOpt("cache", "go-mod") // --label devsh.cache=go-mod
+func dockerLabelOpt(name, value string) string {
+	return "--label " + shellQuote(dockerLabelPrefix+name+"="+value)
+}
+
 // Returns ID of the docker container with the given name
 func dockerContainerId(name string) string {
 	opts := []string{
@@ -105,7 +174,96 @@ func dockerContainerId(name string) string {
 	return out
 }
 
+// Returns the number of commands running in the docker container with the
+// given name via docker exec
+func dockerContainerExecCount(name string) int {
+	if !dockerIsContainerPresent(name) {
+		return 0
+	}
+	opts := []string{
+		"-f '{{len .ExecIDs}}'",
+	}
+	dockerCmd := dockerConstructCmd("inspect", opts, name)
+	count, _ := strconv.Atoi(dockerRunCmd(dockerCmd))
+
+	return count
+}
+
+// Returns ID of the local image with the given name, or an empty string if
+// the image is not present
+func dockerImageId(name string) string {
+	if !dockerIsImagePresent(name) {
+		return ""
+	}
+	opts := []string{
+		"inspect",
+		"-f '{{.Id}}'",
+	}
+	return dockerRunCmd(dockerConstructCmd("image", opts, shellQuote(name)))
+}
+
+// Returns ID of the image the docker container with the given name was
+// created from
+func dockerContainerImageId(name string) string {
+	opts := []string{
+		"-f '{{.Image}}'",
+	}
+	return dockerRunCmd(dockerConstructCmd("inspect", opts, name))
+}
+
+// Returns the image the docker container with the given name was created
+// from, as it was given to docker run
+func dockerContainerImage(name string) string {
+	opts := []string{
+		"-f '{{.Config.Image}}'",
+	}
+	return dockerRunCmd(dockerConstructCmd("inspect", opts, name))
+}
+
+// Returns the value of a devsh label of the docker container with the given
+// name, or an empty string if the container does not have the label
+func dockerContainerLabel(name string, label string) string {
+	opts := []string{
+		"-f " + shellQuote(`{{index .Config.Labels "`+dockerLabelPrefix+label+`"}}`),
+	}
+	return dockerRunCmd(dockerConstructCmd("inspect", opts, name))
+}
+
 // Returns shortened ID of the docker container with the given name
 func dockerContainerIdShort(name string) string {
 	return dockerContainerId(name)[0:dockerIdShortSize]
 }
+
+// Returns the IP address of the docker container with the given name on the
+// given network, or on its first network if network is empty
+func dockerContainerIp(name string, network string) string {
+	format := `{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}`
+	if network != "" {
+		format = `{{with index .NetworkSettings.Networks "` + network + `"}}{{.IPAddress}}{{end}}`
+	}
+	opts := []string{
+		"-f " + shellQuote(format),
+	}
+	dockerCmd := dockerConstructCmd("inspect", opts, name)
+	ip, _, _ := strings.Cut(dockerRunCmd(dockerCmd), " ")
+
+	return ip
+}
+
+// Quotes s so that it is passed as a single argument in a shell command.
+// Strings that consist of safe characters only are returned as is.
+//
+// For example:
+//
+//	shellQuote("FOO=bar")   // FOO=bar
+//	shellQuote("echo 'hi'") // 'echo '\''hi'\'''
+func shellQuote(s string) string {
+	safe := func(r rune) bool {
+		return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			strings.ContainsRune("@%+=:,./_-", r)
+	}
+	if s != "" && strings.IndexFunc(s, func(r rune) bool { return !safe(r) }) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
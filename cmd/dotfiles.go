@@ -0,0 +1,100 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+)
+
+const (
+	// folder inside the container user's home the dotfiles are copied to
+	dotfilesDir = ".dotfiles"
+
+	// file created in the container once the dotfiles are installed, followed
+	// by the container id; it is kept out of the home folder, which may be a
+	// volume that outlives the container
+	dotfilesMarker = "/tmp/devsh-dotfiles-"
+
+	// install command used when none is configured: runs the first of the
+	// conventional install scripts found in the dotfiles
+	dotfilesDefaultInstall = `for f in install.sh install bootstrap.sh bootstrap setup.sh setup; do if [ -f "$f" ]; then chmod +x "$f" && exec "./$f"; fi; done`
+)
+
+// DotfilesConfig describes the dotfiles installed into every dev container.
+type DotfilesConfig struct {
+	Path    string `yaml:"path,omitempty"`
+	Install string `yaml:"install,omitempty"`
+}
+
+// dotfilesPath returns the path to the dotfiles on the host, or an empty
+// string if no dotfiles are configured.
+func dotfilesPath(cfg ConfigValues) string {
+	if cfg.Dotfiles == nil || cfg.Dotfiles.Path == "" {
+		return ""
+	}
+	return expandTilde(cfg.Dotfiles.Path)
+}
+
+// dotfilesInstall copies the dotfiles into the home folder of the container
+// user (to ~/.dotfiles) and runs the install command there. Once the install
+// command succeeds, a marker file for the container is created in /tmp, so the
+// dotfiles are installed once per dev container: it is called whenever the
+// dev container is started or a shell is opened in it, and returns right away
+// if the marker exists. A failed installation is retried the next time. A
+// failure does not prevent the dev container from being used, so it is only
+// reported as a warning.
+func dotfilesInstall(cfg ConfigValues) {
+	src := dotfilesPath(cfg)
+	if src == "" {
+		return
+	}
+
+	homeCmd := dockerConstructCmd("exec", openExecOpts(cfg), cfg.ContainerName, "/bin/sh -c", shellQuote(`echo "$HOME"`))
+	home := dockerRunCmd(homeCmd)
+	dst := path.Join(home, dotfilesDir)
+	marker := dotfilesMarker + dockerContainerId(cfg.ContainerName)
+
+	testCmd := dockerConstructCmd("exec", openExecOpts(cfg), cfg.ContainerName, "test -f", shellQuote(marker))
+	if dockerRunPiped(testCmd, nil, nil) == nil {
+		return
+	}
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		log.Printf("WARN: Dotfiles folder %s is not found, skipping the dotfiles installation", src)
+		return
+	}
+
+	fmt.Printf("* Installing dotfiles from %s\n", src)
+
+	cpCmd := dockerConstructCmd("cp", nil, shellQuote(src+"/."), shellQuote(cfg.ContainerName+":"+dst))
+	if err := dockerRunStreamed(cpCmd); err != nil {
+		log.Printf("WARN: Failed to copy dotfiles into the dev container: %s", err)
+		return
+	}
+	if cfg.User != "" {
+		// docker cp creates the files as root
+		chownCmd := dockerConstructCmd("exec", []string{"--user root"}, cfg.ContainerName, "chown -R", shellQuote(cfg.User), shellQuote(dst))
+		if err := dockerRunStreamed(chownCmd); err != nil {
+			log.Printf("WARN: Failed to change the owner of the dotfiles in the dev container: %s", err)
+			return
+		}
+	}
+
+	install := cfg.Dotfiles.Install
+	if install == "" {
+		install = dotfilesDefaultInstall
+	}
+	opts := append(openExecOpts(cfg), "--workdir "+shellQuote(dst))
+	installCmd := dockerConstructCmd("exec", opts, cfg.ContainerName, "/bin/sh -c", shellQuote(install))
+	if err := dockerRunStreamed(installCmd); err != nil {
+		log.Printf("WARN: Dotfiles install command failed in the dev container, it is retried the next time: %s", err)
+		return
+	}
+
+	touchCmd := dockerConstructCmd("exec", openExecOpts(cfg), cfg.ContainerName, "touch", shellQuote(marker))
+	if err := dockerRunStreamed(touchCmd); err != nil {
+		log.Printf("WARN: Failed to mark the dotfiles as installed in the dev container: %s", err)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// An export is a gzipped tar archive with the following entries, in order:
+// the manifest, the config, the images saved by docker save, and one tar
+// archive per volume.
+const (
+	exportVersion      = 1
+	exportManifestName = "manifest.yaml"
+	exportConfigName   = "devsh.yaml"
+	exportImagesName   = "images.tar"
+	exportVolumesDir   = "volumes"
+)
+
+// exportManifest describes the contents of an export.
+type exportManifest struct {
+	Version int       `yaml:"version"`
+	Created time.Time `yaml:"created"`
+	Project string    `yaml:"project"`
+	// Image is the image of the dev container, which also restores the
+	// volumes
+	Image   string         `yaml:"image"`
+	Images  []string       `yaml:"images"`
+	Volumes []exportVolume `yaml:"volumes,omitempty"`
+}
+
+// exportVolume is a named volume included in an export.
+type exportVolume struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export the dev environment to an archive",
+	Long: `Export the dev environment of the project to a single archive, which
+"devsh import" restores on another machine, e.g. one without network access.
+The archive holds the effective config, the images of the dev container and
+its services (saved with docker save), and optionally named volumes, e.g. the
+database of a service or the persistent home folder.
+
+The archive is written to <name>.devsh.tar.gz unless a file is given. The
+config is exported with every value from the global config, the profile, the
+environment and the flags applied, so it may contain secrets set in env. Paths
+in the project folder are exported relative to ${project.dir}, so they follow
+the folder the archive is imported into.
+
+For example:
+	devsh export
+	devsh export --with-volume myproject_pgdata /media/usb/myproject.tar.gz
+	devsh export --all-volumes
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configLoad(cmd)
+		configReportWarnings()
+		if cfg.Image == "" {
+			log.Fatal("ERROR: Docker image for the dev container is not specified")
+		}
+
+		file := cfg.Name + ".devsh.tar.gz"
+		if len(args) == 1 {
+			file = args[0]
+		}
+
+		image := cfg.Image
+		if snapshot := snapshotImage(cfg); snapshot != "" {
+			image = snapshot
+		}
+		images := []string{image}
+		seen := map[string]bool{image: true}
+		for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+			if svc := cfg.Services[name]; !seen[svc.Image] {
+				seen[svc.Image] = true
+				images = append(images, svc.Image)
+			}
+		}
+		slices.Sort(images[1:])
+		for _, image := range images {
+			if !dockerIsImagePresent(image) {
+				log.Fatalf("ERROR: Image %s is not present locally, pull it with `devsh pull` first", image)
+			}
+		}
+
+		volumes, _ := cmd.Flags().GetStringArray("with-volume")
+		if all, _ := cmd.Flags().GetBool("all-volumes"); all {
+			volumes = append(volumes, exportNamedVolumes(cfg)...)
+		}
+		volumes = slices.Compact(slices.Sorted(slices.Values(volumes)))
+		for _, volume := range volumes {
+			if !dockerIsVolumePresent(volume) {
+				log.Fatalf("ERROR: Volume %s is not found", volume)
+			}
+		}
+
+		exportArchive(cfg, file, image, images, volumes)
+	},
+}
+
+// exportArchive writes the export of the dev environment to file.
+func exportArchive(cfg ConfigValues, file string, image string, images []string, volumes []string) {
+	tmp, err := os.MkdirTemp("", "devsh-export-")
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create temporary folder: %s", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	manifest := exportManifest{
+		Version: exportVersion,
+		Created: time.Now(),
+		Project: cfg.Name,
+		Image:   image,
+		Images:  images,
+	}
+	config, err := yaml.Marshal(exportConfig(cfg, image))
+	if err != nil {
+		log.Fatalf("ERROR: Failed to serialize config: %s", err)
+	}
+	// the values are already interpolated, keep any '$' in them literal
+	config = bytes.ReplaceAll(config, []byte("$"), []byte("$$"))
+	config = exportProjectDirVar(config)
+	config = append([]byte(fmt.Sprintf("# Exported by devsh export from %s on %s\n", cfg.Name, manifest.Created.Format(time.DateOnly))), config...)
+
+	fmt.Printf("* Saving images %s\n", strings.Join(images, ", "))
+	imagesPath := filepath.Join(tmp, exportImagesName)
+	quoted := make([]string, 0, len(images))
+	for _, image := range images {
+		quoted = append(quoted, shellQuote(image))
+	}
+	if err := dockerRunStreamed(dockerConstructCmd("save", []string{"-o " + shellQuote(imagesPath)}, quoted...)); err != nil {
+		log.Fatalf("ERROR: Failed to save images: %s", err)
+	}
+
+	var volumePaths []string
+	for _, volume := range volumes {
+		fmt.Printf("* Saving volume %s\n", volume)
+		manifest.Volumes = append(manifest.Volumes, exportVolume{Name: volume, Labels: exportVolumeLabels(volume)})
+		volumePath := filepath.Join(tmp, volume+".tar")
+		if err := exportVolumeData(volume, image, volumePath); err != nil {
+			log.Fatalf("ERROR: Failed to save volume %s: %s", volume, err)
+		}
+		volumePaths = append(volumePaths, volumePath)
+	}
+
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to serialize manifest: %s", err)
+	}
+
+	out, err := os.Create(file)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to create %s: %s", file, err)
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	err = exportAddData(tw, exportManifestName, data)
+	if err == nil {
+		err = exportAddData(tw, exportConfigName, config)
+	}
+	if err == nil {
+		err = exportAddFile(tw, exportImagesName, imagesPath)
+	}
+	for _, volumePath := range volumePaths {
+		if err == nil {
+			err = exportAddFile(tw, path.Join(exportVolumesDir, filepath.Base(volumePath)), volumePath)
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		os.Remove(file)
+		log.Fatalf("ERROR: Failed to write %s: %s", file, err)
+	}
+
+	fmt.Printf("* Exported the dev environment to %s\n", file)
+}
+
+// exportConfig returns the config to export: the effective config with the
+// image replaced by the exported one, and without the values that only make
+// sense on this machine, or that are already applied.
+func exportConfig(cfg ConfigValues, image string) ConfigValues {
+	// names derived from the project folder are derived again on import
+	if cfg.ContainerName == configDefaultContainerName(cfg) {
+		cfg.ContainerName = ""
+	}
+	switch cfg.Network {
+	case networkProjectName(cfg):
+		cfg.Network = networkProject
+	case networkSharedName:
+		cfg.Network = networkShared
+	}
+
+	cfg.Image = image
+	cfg.Build = nil
+	cfg.Compose = nil
+	cfg.Snapshot = ""
+	cfg.Dotfiles = nil
+	cfg.Extends = ""
+	cfg.Profile = ""
+	cfg.Profiles = nil
+	return cfg
+}
+
+// exportProjectDirVar replaces the project folder in the exported config with
+// ${project.dir}, so that paths in the project folder point to the folder the
+// config is imported into.
+func exportProjectDirVar(config []byte) []byte {
+	dir := strings.ReplaceAll(configProjectDir(), "$", "$$")
+	if dir == "/" {
+		return config
+	}
+	// only whole path elements, not e.g. the folder /src/app2 of /src/app
+	re := regexp.MustCompile(regexp.QuoteMeta(dir) + `([^\w.-]|$)`)
+	return re.ReplaceAllFunc(config, func(m []byte) []byte {
+		return append([]byte("${project.dir}"), m[len(dir):]...)
+	})
+}
+
+// exportNamedVolumes returns the named volumes used by the dev container and
+// its services that exist: the ones in volumes, the caches and the persistent
+// home folder.
+func exportNamedVolumes(cfg ConfigValues) []string {
+	specs := slices.Clone(cfg.Volumes)
+	for _, svc := range cfg.Services {
+		specs = append(specs, svc.Volumes...)
+	}
+
+	var volumes []string
+	for _, spec := range specs {
+		source, _, ok := strings.Cut(spec, ":")
+		if ok && source != "" && !strings.ContainsAny(source[:1], "/.~$") {
+			volumes = append(volumes, source)
+		}
+	}
+	for _, m := range cacheMounts(cfg) {
+		volumes = append(volumes, cacheVolumeName(cfg, m.name))
+	}
+	if configBool(cfg.PersistHome) {
+		volumes = append(volumes, homeVolumeName(cfg))
+	}
+	return slices.DeleteFunc(volumes, func(volume string) bool { return !dockerIsVolumePresent(volume) })
+}
+
+// exportVolumeLabels returns the labels of a volume.
+func exportVolumeLabels(volume string) map[string]string {
+	opts := []string{
+		"inspect",
+		"-f '{{json .Labels}}'",
+	}
+	out := dockerRunCmd(dockerConstructCmd("volume", opts, shellQuote(volume)))
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(out), &labels); err != nil {
+		log.Fatalf("ERROR: Failed to read labels of volume %s: %s", volume, err)
+	}
+	return labels
+}
+
+// exportVolumeData writes the contents of a volume as a tar archive to a file.
+// The archive is created by tar in a container of the given image.
+func exportVolumeData(volume string, image string, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	opts := []string{
+		"--rm",
+		"--user 0",
+		"--entrypoint tar",
+		"--volume " + shellQuote(volume+":/volume"),
+	}
+	return dockerRunPiped(dockerConstructCmd("run", opts, shellQuote(image), "-C /volume -cf - ."), nil, f)
+}
+
+// exportAddData adds an entry with the given contents to the archive.
+func exportAddData(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// exportAddFile adds an entry with the contents of a file to the archive.
+func exportAddFile(tw *tar.Writer, name string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringArray("with-volume", nil, "Named volume to include in the archive, can be repeated")
+	exportCmd.Flags().Bool("all-volumes", false, "Include the named volumes of the dev container and its services, its caches and its home folder")
+}
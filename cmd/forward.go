@@ -0,0 +1,323 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// address the forwarded ports listen on, unless one is given
+const forwardDefaultIp = "127.0.0.1"
+
+// forward is a port of the dev container forwarded to the host by a proxy
+// process running in the background. Forwards are tracked in the state
+// folder, one file per forward, named after its host port.
+type forward struct {
+	Pid           int       `yaml:"pid"`
+	Listen        string    `yaml:"listen"`
+	Target        string    `yaml:"target"`
+	ContainerPort string    `yaml:"container_port"`
+	Started       time.Time `yaml:"started"`
+}
+
+// forwardCmd represents the forward command
+var forwardCmd = &cobra.Command{
+	Use:   "forward [[ip:]host_port:]container_port",
+	Short: "Forward a port of the running dev container to the host",
+	Long: `Forward a port of the running dev container to the host, without recreating
+the dev container as publishing it with the ports key would. A proxy process
+running in the background on the host accepts connections on the host port
+(on 127.0.0.1, unless an IP address is given) and relays them to the IP address
+of the dev container. Use auto as the host port to pick a free one.
+
+Forwards end with "devsh forward --stop", or when the dev container is stopped.
+Forwarding needs the container IP addresses to be reachable from the host,
+which is not the case with Docker Desktop.
+
+For example:
+	devsh forward 3000 # localhost:3000 to port 3000 of the dev container
+	devsh forward 8000:3000
+	devsh forward auto:3000
+	devsh forward --list
+	devsh forward --stop 8000
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configLoad(cmd)
+
+		list, _ := cmd.Flags().GetBool("list")
+		stop, _ := cmd.Flags().GetBool("stop")
+		switch {
+		case list:
+			forwardDisplayList(cfg)
+		case stop:
+			forwardStop(cfg, args)
+		case len(args) == 1:
+			forwardStart(cfg, args[0])
+		default:
+			log.Fatal("ERROR: Expected the port to forward, --list or --stop")
+		}
+	},
+}
+
+// forwardProxyCmd represents the hidden forward-proxy command
+var forwardProxyCmd = &cobra.Command{
+	Use:    "forward-proxy <listen address> <target address>",
+	Short:  "Relay TCP connections to an address",
+	Hidden: true,
+	Args:   cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		forwardProxy(args[0], args[1])
+	},
+}
+
+// forwardDir returns the folder with the forwards of a container.
+func forwardDir(containerName string) string {
+	return filepath.Join(configStateDir(), "forwards", containerName)
+}
+
+// forwardStart starts a proxy process that forwards a port of the running dev
+// container to the host.
+func forwardStart(cfg ConfigValues, spec string) {
+	m, err := portsParse(spec)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	if m.proto != "tcp" {
+		log.Fatalf("ERROR: Only TCP ports can be forwarded, got %s", spec)
+	}
+	if _, err := strconv.Atoi(m.containerPort); err != nil {
+		log.Fatalf("ERROR: Invalid container port %s", m.containerPort)
+	}
+	if m.ip == "" {
+		m.ip = forwardDefaultIp
+	}
+	switch m.hostPort {
+	case "":
+		m.hostPort = m.containerPort
+	case portsAuto:
+		port, err := portsFree(m.ip, m.proto)
+		if err != nil {
+			log.Fatalf("ERROR: Failed to find a free host port for %s: %s", spec, err)
+		}
+		m.hostPort = strconv.Itoa(port)
+	}
+	portsCheckFree(m, "forward "+spec)
+
+	if !dockerIsContainerRunning(cfg.ContainerName) {
+		log.Fatalf("ERROR: The dev container %s is not running, start it first", cfg.ContainerName)
+	}
+	ip := dockerContainerIp(cfg.ContainerName, cfg.Network)
+	if ip == "" {
+		log.Fatalf("ERROR: The dev container %s has no IP address to forward to", cfg.ContainerName)
+	}
+
+	f := forward{
+		Listen:        net.JoinHostPort(m.ip, m.hostPort),
+		Target:        net.JoinHostPort(ip, m.containerPort),
+		ContainerPort: m.containerPort,
+		Started:       time.Now(),
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("ERROR: Failed to locate the devsh binary: %s", err)
+	}
+	proxy := exec.Command(exe, "forward-proxy", f.Listen, f.Target)
+	proxy.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := proxy.Start(); err != nil {
+		log.Fatalf("ERROR: Failed to start the port forwarding: %s", err)
+	}
+	f.Pid = proxy.Process.Pid
+	proxy.Process.Release()
+
+	// wait for the proxy to listen, so that the port can be used right away
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", f.Listen); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) || !sessionIsAlive(f.Pid) {
+			log.Fatalf("ERROR: Failed to forward %s to port %s of the dev container", f.Listen, f.ContainerPort)
+		}
+	}
+
+	dir := forwardDir(cfg.ContainerName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalf("ERROR: Failed to create state folder %s: %s", dir, err)
+	}
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to serialize forward: %s", err)
+	}
+	path := filepath.Join(dir, m.hostPort)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatalf("ERROR: Failed to write forward %s: %s", path, err)
+	}
+
+	fmt.Printf("* Forwarding %s to port %s of the dev container %s\n", f.Listen, f.ContainerPort, cfg.ContainerName)
+}
+
+// forwardList returns the forwards of the dev container by their host port.
+// Forwards whose proxy process is gone are removed on the way.
+func forwardList(cfg ConfigValues) map[string]forward {
+	dir := forwardDir(cfg.ContainerName)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read forwards %s: %s", dir, err)
+	}
+
+	forwards := map[string]forward{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var f forward
+		if err := yaml.Unmarshal(data, &f); err != nil || !forwardIsAlive(f) {
+			os.Remove(path)
+			continue
+		}
+		forwards[entry.Name()] = f
+	}
+	return forwards
+}
+
+// forwardIsAlive returns true if the proxy process of a forward is still
+// running. The process is identified by its command line, so that a process
+// that got the pid of the proxy after it ended (e.g. after a reboot) is not
+// taken for it.
+func forwardIsAlive(f forward) bool {
+	if f.Pid <= 0 || !sessionIsAlive(f.Pid) {
+		return false
+	}
+
+	var args []string
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", f.Pid)); err == nil {
+		args = strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+	} else if out, err := exec.Command("ps", "-o", "args=", "-p", strconv.Itoa(f.Pid)).Output(); err == nil {
+		// no /proc on macOS
+		args = strings.Fields(string(out))
+	}
+	i := slices.Index(args, forwardProxyCmd.Name())
+	return i >= 0 && len(args) >= i+3 && args[i+1] == f.Listen && args[i+2] == f.Target
+}
+
+// forwardStop stops the forwards of the given host ports, or all forwards of
+// the dev container if no ports are given.
+func forwardStop(cfg ConfigValues, ports []string) {
+	forwards := forwardList(cfg)
+	if len(ports) == 0 {
+		for port := range forwards {
+			ports = append(ports, port)
+		}
+	}
+
+	for _, port := range slices.Sorted(slices.Values(ports)) {
+		f, ok := forwards[port]
+		if !ok {
+			log.Fatalf("ERROR: Host port %s is not forwarded, see `devsh forward --list`", port)
+		}
+		// the process may be gone since the forwards were listed, and its pid
+		// be reused by another process
+		if forwardIsAlive(f) {
+			syscall.Kill(f.Pid, syscall.SIGTERM)
+		}
+		os.Remove(filepath.Join(forwardDir(cfg.ContainerName), port))
+		fmt.Printf("* Stopped forwarding %s to port %s\n", f.Listen, f.ContainerPort)
+	}
+}
+
+// forwardDisplayList prints the forwards of the dev container as a table.
+func forwardDisplayList(cfg ConfigValues) {
+	forwards := forwardList(cfg)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCONTAINER PORT\tTARGET\tPID\tSTARTED")
+	for _, port := range slices.Sorted(maps.Keys(forwards)) {
+		f := forwards[port]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s ago\n", f.Listen, f.ContainerPort, f.Target, f.Pid, time.Since(f.Started).Round(time.Second))
+	}
+	w.Flush()
+}
+
+// forwardDisplay prints the forwards of the dev container.
+func forwardDisplay(cfg ConfigValues) {
+	forwards := forwardList(cfg)
+	if len(forwards) == 0 {
+		return
+	}
+
+	fmt.Println("* Forwarded ports:")
+	for _, port := range slices.Sorted(maps.Keys(forwards)) {
+		f := forwards[port]
+		fmt.Printf("  - %s -> %s (pid %d)\n", f.Listen, f.ContainerPort, f.Pid)
+	}
+}
+
+// forwardProxy accepts TCP connections on the listen address and relays them
+// to the target address, until it is terminated.
+func forwardProxy(listen, target string) {
+	signal.Ignore(syscall.SIGHUP)
+
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to listen on %s: %s", listen, err)
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatalf("ERROR: Failed to accept connections on %s: %s", listen, err)
+		}
+		go func() {
+			defer conn.Close()
+			upstream, err := net.DialTimeout("tcp", target, 10*time.Second)
+			if err != nil {
+				return
+			}
+			defer upstream.Close()
+
+			done := make(chan bool, 2)
+			go func() {
+				io.Copy(upstream, conn)
+				upstream.(*net.TCPConn).CloseWrite()
+				done <- true
+			}()
+			go func() {
+				io.Copy(conn, upstream)
+				conn.(*net.TCPConn).CloseWrite()
+				done <- true
+			}()
+			<-done
+			<-done
+		}()
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(forwardCmd, forwardProxyCmd)
+
+	forwardCmd.Flags().Bool("list", false, "List the forwarded ports of the dev container")
+	forwardCmd.Flags().Bool("stop", false, "Stop forwarding the given host port, or all ports")
+}
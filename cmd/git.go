@@ -0,0 +1,87 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// gitForwardedKeys lists the git settings of the host that are forwarded into
+// the dev container with git_config. These are limited to the identity and to
+// settings that neither refer to files or programs on the host nor may carry
+// credentials.
+var gitForwardedKeys = []string{
+	"user.name",
+	"user.email",
+	"init.defaultBranch",
+	"pull.rebase",
+	"pull.ff",
+	"push.default",
+	"push.autoSetupRemote",
+	"fetch.prune",
+	"rebase.autoStash",
+	"merge.conflictStyle",
+	"diff.algorithm",
+	"core.autocrlf",
+	"color.ui",
+}
+
+// gitRunOpts returns the options that forward the git identity and settings
+// of the host into the dev container when it is created. The settings are
+// passed as GIT_CONFIG_* environment variables (supported by git 2.31 and
+// later), so the container's git config files are left untouched. The
+// identity is also passed as GIT_AUTHOR_* and GIT_COMMITTER_* variables for
+// older git versions. The project folder is marked as a safe directory, as it
+// is owned by the host user.
+func gitRunOpts(cfg ConfigValues) []string {
+	if !configBool(cfg.GitConfig) {
+		return nil
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		log.Printf("WARN: git_config is enabled, but git is not installed on the host")
+		return nil
+	}
+
+	var env []string
+	var settings [][2]string
+	for _, key := range gitForwardedKeys {
+		if value, ok := gitHostConfig(key); ok {
+			settings = append(settings, [2]string{key, value})
+		}
+	}
+	settings = append(settings, [2]string{"safe.directory", cfg.ContainerDir})
+
+	env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(settings)))
+	for i, s := range settings {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, s[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, s[1]),
+		)
+	}
+
+	if name, ok := gitHostConfig("user.name"); ok {
+		env = append(env, "GIT_AUTHOR_NAME="+name, "GIT_COMMITTER_NAME="+name)
+	}
+	if email, ok := gitHostConfig("user.email"); ok {
+		env = append(env, "GIT_AUTHOR_EMAIL="+email, "GIT_COMMITTER_EMAIL="+email)
+	}
+
+	var opts []string
+	for _, e := range env {
+		opts = append(opts, "--env "+shellQuote(e))
+	}
+	return opts
+}
+
+// gitHostConfig returns the value of a git setting on the host, as seen from
+// the project folder. The second return value is false if it is not set.
+func gitHostConfig(key string) (string, bool) {
+	out, err := exec.Command("git", "-C", configProjectDir(), "config", "--get", key).Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// conditions of depends_on: the container is running, or it is running
+	// and its health check passes
+	healthStarted = "started"
+	healthHealthy = "healthy"
+
+	// how long to wait for the containers to be ready, unless wait_timeout
+	// is set
+	healthDefaultTimeout = 2 * time.Minute
+)
+
+// HealthcheckConfig describes the command docker runs periodically inside a
+// container to check that it is healthy.
+type HealthcheckConfig struct {
+	Test        string `yaml:"test,omitempty"`
+	Interval    string `yaml:"interval,omitempty"`
+	Timeout     string `yaml:"timeout,omitempty"`
+	Retries     int    `yaml:"retries,omitempty"`
+	StartPeriod string `yaml:"start_period,omitempty"`
+}
+
+// healthcheckOpts returns the options that set up the health check of a
+// container.
+func healthcheckOpts(hc *HealthcheckConfig) []string {
+	if hc == nil || hc.Test == "" {
+		return nil
+	}
+	opts := []string{
+		"--health-cmd " + shellQuote(hc.Test),
+	}
+	if hc.Interval != "" {
+		opts = append(opts, "--health-interval "+shellQuote(hc.Interval))
+	}
+	if hc.Timeout != "" {
+		opts = append(opts, "--health-timeout "+shellQuote(hc.Timeout))
+	}
+	if hc.Retries > 0 {
+		opts = append(opts, fmt.Sprintf("--health-retries %d", hc.Retries))
+	}
+	if hc.StartPeriod != "" {
+		opts = append(opts, "--health-start-period "+shellQuote(hc.StartPeriod))
+	}
+	return opts
+}
+
+// healthValidate fails with an error if depends_on of the dev container or
+// of a service refers to an unknown service or condition, or if the services
+// depend on each other in a cycle. It is called before any container is
+// created.
+func healthValidate(cfg ConfigValues) {
+	healthTimeout(cfg)
+	check := func(what string, dependsOn map[string]string) {
+		for _, name := range slices.Sorted(maps.Keys(dependsOn)) {
+			if _, ok := cfg.Services[name]; !ok {
+				log.Fatalf("ERROR: The %s depends on %s, which is not a service", what, name)
+			}
+			if condition := dependsOn[name]; condition != healthStarted && condition != healthHealthy {
+				log.Fatalf("ERROR: Invalid condition %q for %s in depends_on of the %s, expected %s or %s",
+					condition, name, what, healthStarted, healthHealthy)
+			}
+		}
+	}
+	check("dev container", cfg.DependsOn)
+	for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+		check("service "+name, cfg.Services[name].DependsOn)
+	}
+	serviceOrder(cfg)
+}
+
+// healthTimeout returns how long to wait for a container to be ready.
+func healthTimeout(cfg ConfigValues) time.Duration {
+	if cfg.WaitTimeout == "" {
+		return healthDefaultTimeout
+	}
+	timeout, err := time.ParseDuration(cfg.WaitTimeout)
+	if err != nil || timeout <= 0 {
+		log.Fatalf("ERROR: Invalid wait_timeout %s, expected a duration like 90s or 2m", cfg.WaitTimeout)
+	}
+	return timeout
+}
+
+// healthWaitReady waits until the services the dev container depends on meet
+// their conditions, and the dev container itself is healthy if it has a
+// health check. Without depends_on, the dev container depends on every
+// service: on it being healthy if the service has a health check, or else
+// just started.
+func healthWaitReady(cfg ConfigValues) {
+	dependsOn := cfg.DependsOn
+	if len(dependsOn) == 0 {
+		dependsOn = map[string]string{}
+		for name, svc := range cfg.Services {
+			dependsOn[name] = healthStarted
+			if svc.Healthcheck != nil && svc.Healthcheck.Test != "" {
+				dependsOn[name] = healthHealthy
+			}
+		}
+	}
+	healthWaitDependencies(cfg, dependsOn)
+
+	if cfg.Healthcheck != nil && cfg.Healthcheck.Test != "" {
+		healthWait(cfg, "dev container", cfg.ContainerName, healthHealthy)
+	}
+}
+
+// healthWaitDependencies waits until the services in dependsOn meet their
+// conditions.
+func healthWaitDependencies(cfg ConfigValues, dependsOn map[string]string) {
+	for _, name := range slices.Sorted(maps.Keys(dependsOn)) {
+		healthWait(cfg, "service "+name, serviceContainerName(cfg, name), dependsOn[name])
+	}
+}
+
+// healthState returns the state of a container (e.g. running or exited, or
+// empty if it does not exist) and its health (starting, healthy, unhealthy,
+// or empty without a health check).
+func healthState(container string) (string, string) {
+	if !dockerIsContainerPresent(container) {
+		return "", ""
+	}
+	opts := []string{
+		"-f " + shellQuote(`{{.State.Status}}|{{if .State.Health}}{{.State.Health.Status}}{{end}}`),
+	}
+	out := dockerRunCmd(dockerConstructCmd("inspect", opts, container))
+	state, health, _ := strings.Cut(out, "|")
+	return state, health
+}
+
+// healthWait waits until a container meets the condition, showing the time
+// waited so far on the terminal. It fails with an error if the container
+// stops, becomes unhealthy, or the timeout passes.
+func healthWait(cfg ConfigValues, what, container, condition string) {
+	timeout := healthTimeout(cfg)
+	started := time.Now()
+	interactive := termIsTerminal(os.Stdout.Fd())
+	spinner := []string{"|", "/", "-", "\\"}
+
+	progress := func(format string, args ...any) {
+		if interactive {
+			fmt.Print("\r\033[K")
+		}
+		fmt.Printf(format, args...)
+	}
+	shown := false
+	for i := 0; ; i++ {
+		state, health := healthState(container)
+		switch state {
+		case "running":
+		case "":
+			progress("")
+			log.Fatalf("ERROR: The %s does not exist, restart the dev container with `devsh stop` and `devsh start`", what)
+		default:
+			progress("")
+			log.Fatalf("ERROR: The %s is %s instead of running, see `docker logs %s`", what, state, container)
+		}
+		ready := condition == healthStarted
+		if condition == healthHealthy {
+			switch health {
+			case "":
+				progress("")
+				log.Fatalf("ERROR: The %s has no health check to wait for, add a healthcheck or depend on it being %s", what, healthStarted)
+			case "unhealthy":
+				progress("")
+				log.Fatalf("ERROR: The %s is unhealthy, see `docker inspect %s` for the output of the health check", what, container)
+			case "healthy":
+				ready = true
+			}
+		}
+
+		elapsed := time.Since(started).Round(time.Second)
+		if ready {
+			if shown {
+				progress("* The %s is %s (%s)\n", what, condition, elapsed)
+			}
+			return
+		}
+		if elapsed > timeout {
+			progress("")
+			log.Fatalf("ERROR: Timed out after %s waiting for the %s to be %s, see wait_timeout", timeout, what, condition)
+		}
+		if interactive {
+			progress("%s Waiting for the %s to be %s (%s)", spinner[i%len(spinner)], what, condition, elapsed)
+		} else if !shown {
+			fmt.Printf("* Waiting for the %s to be %s\n", what, condition)
+		}
+		shown = true
+		time.Sleep(500 * time.Millisecond)
+	}
+}
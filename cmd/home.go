@@ -0,0 +1,57 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"log"
+	"strings"
+)
+
+const (
+	homeVolumePrefix = "devsh-home-"
+)
+
+// homeVolume returns the volume that keeps the home folder of the container
+// user, e.g. "devsh-home-devsh-a1b2:/root", and creates the volume if it does
+// not exist yet. The volume belongs to the project and survives the removal
+// of the dev container.
+//
+// A new volume is populated by docker with the contents of the home folder in
+// the image the first time it is mounted.
+func homeVolume(cfg ConfigValues) string {
+	name := homeVolumeName(cfg)
+	if !dockerIsVolumePresent(name) {
+		opts := []string{
+			"create",
+			dockerLabelOpt("home", configProjectDir()),
+		}
+		dockerRunCmd(dockerConstructCmd("volume", opts, shellQuote(name)))
+	}
+
+	return name + ":" + homeImageUserDir(cfg)
+}
+
+// homeVolumeName returns the name of the home volume of the project, e.g.
+// devsh-home-devsh-a1b2.
+func homeVolumeName(cfg ConfigValues) string {
+	return homeVolumePrefix + cfg.Name + "-" + configProjectPathHash()
+}
+
+// homeImageUserDir returns the home folder of the container user (the user
+// the shell runs as) as defined in the image of the dev container.
+func homeImageUserDir(cfg ConfigValues) string {
+	opts := []string{
+		"--rm",
+		"--entrypoint /bin/sh",
+	}
+	if cfg.User != "" {
+		opts = append(opts, "--user "+shellQuote(cfg.User))
+	}
+	out := dockerRunCmd(dockerConstructCmd("run", opts, shellQuote(cfg.Image), "-c", shellQuote(`echo "$HOME"`)))
+
+	home := strings.TrimSpace(out)
+	if !strings.HasPrefix(home, "/") || home == "/" {
+		log.Fatalf("ERROR: Failed to determine the home folder of the container user in image %s (got %q)", cfg.Image, home)
+	}
+	return home
+}
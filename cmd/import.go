@@ -0,0 +1,209 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a dev environment from an archive",
+	Long: `Import a dev environment exported by "devsh export" into the current folder:
+the config is written to .devsh, the images are loaded into docker, and the
+volumes in the archive are created and restored. Nothing is pulled, so the
+archive can be imported on a machine without network access.
+
+The home folder and project-scoped caches belong to the project folder, their
+volumes are renamed for the current folder. Other volumes keep their names.
+
+The import fails if .devsh or any of the volumes already exists, unless
+--force is given, in which case they are replaced. Global caches are shared
+with other projects, an existing one is kept as it is.
+
+For example:
+	devsh import myproject.devsh.tar.gz && devsh
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		file := args[0]
+
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatalf("ERROR: Failed to open %s: %s", file, err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			log.Fatalf("ERROR: Failed to read %s: %s", file, err)
+		}
+		tr := tar.NewReader(gz)
+
+		var manifest exportManifest
+		volumes := map[string]exportVolume{} // by the entry name
+		skipped := map[string]bool{}         // entries of volumes that are kept
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				log.Fatalf("ERROR: Failed to read %s: %s", file, err)
+			}
+
+			switch {
+			case header.Name == exportManifestName:
+				manifest = importManifest(tr, file)
+				for _, v := range manifest.Volumes {
+					entry := path.Join(exportVolumesDir, v.Name+".tar")
+					if target, ok := importVolumeTarget(manifest, v, force); ok {
+						volumes[entry] = target
+					} else {
+						skipped[entry] = true
+					}
+				}
+
+			case manifest.Version == 0:
+				log.Fatalf("ERROR: %s is not an archive created by `devsh export`", file)
+
+			case header.Name == exportConfigName:
+				importConfig(tr, force)
+
+			case header.Name == exportImagesName:
+				fmt.Printf("* Loading images %s\n", strings.Join(manifest.Images, ", "))
+				if err := dockerRunPiped(dockerConstructCmd("load", []string{"--quiet"}), tr, os.Stdout); err != nil {
+					log.Fatalf("ERROR: Failed to load images: %s", err)
+				}
+
+			case skipped[header.Name]:
+				continue
+
+			default:
+				v, ok := volumes[header.Name]
+				if !ok {
+					log.Printf("WARN: Ignoring unknown entry %s in %s", header.Name, file)
+					continue
+				}
+				importVolume(v, manifest.Image, tr, force)
+				delete(volumes, header.Name)
+			}
+		}
+
+		if manifest.Version == 0 {
+			log.Fatalf("ERROR: %s is not an archive created by `devsh export`", file)
+		}
+		if len(volumes) > 0 {
+			log.Fatalf("ERROR: %s is incomplete, volumes missing: %s", file, strings.Join(slices.Sorted(maps.Keys(volumes)), ", "))
+		}
+		fmt.Printf("* Imported the dev environment of %s, run `devsh` to start it\n", manifest.Project)
+	},
+}
+
+// importManifest reads the manifest of an export, and checks that this
+// version of devsh can import it.
+func importManifest(r io.Reader, file string) exportManifest {
+	var manifest exportManifest
+	data, err := io.ReadAll(r)
+	if err == nil {
+		err = yaml.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read the manifest of %s: %s", file, err)
+	}
+	if manifest.Version != exportVersion {
+		log.Fatalf("ERROR: %s has version %d, this version of devsh imports version %d", file, manifest.Version, exportVersion)
+	}
+	return manifest
+}
+
+// importConfig writes the exported config to the config file of the project.
+func importConfig(r io.Reader, force bool) {
+	if _, err := os.Stat(configFilename); err == nil && !force {
+		log.Fatalf("ERROR: Config file %s already exists, use --force to overwrite it", configFilename)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read the exported config: %s", err)
+	}
+	if err := os.WriteFile(configFilename, data, 0o644); err != nil {
+		log.Fatalf("ERROR: Failed to write config file %s: %s", configFilename, err)
+	}
+	fmt.Printf("* Created %s\n", configFilename)
+}
+
+// importVolumeTarget returns the volume to restore an exported volume to. The
+// volumes of the home folder and of project-scoped caches are renamed and
+// relabelled for the current project folder. The second return value is false
+// if the volume is a global cache that already exists: it is shared with other
+// projects, so it is kept even with --force.
+func importVolumeTarget(manifest exportManifest, v exportVolume, force bool) (exportVolume, bool) {
+	cfg := ConfigValues{Name: manifest.Project, CacheScope: cacheScopeProject}
+	labels := maps.Clone(v.Labels)
+	name := v.Name
+	if _, ok := labels[dockerLabelPrefix+"home"]; ok {
+		name = homeVolumeName(cfg)
+		labels[dockerLabelPrefix+"home"] = configProjectDir()
+	}
+	if labels[dockerLabelPrefix+"scope"] == cacheScopeProject {
+		name = cacheVolumeName(cfg, labels[dockerLabelPrefix+"cache"])
+		labels[dockerLabelPrefix+"project"] = configProjectDir()
+	}
+
+	if dockerIsVolumePresent(name) {
+		if labels[dockerLabelPrefix+"scope"] == cacheScopeGlobal {
+			fmt.Printf("* Keeping shared cache volume %s\n", name)
+			return exportVolume{}, false
+		}
+		if !force {
+			log.Fatalf("ERROR: Volume %s already exists, use --force to replace it", name)
+		}
+	}
+	return exportVolume{Name: name, Labels: labels}, true
+}
+
+// importVolume creates a volume and restores its contents from a tar archive.
+// The archive is extracted by tar in a container of the given image.
+func importVolume(v exportVolume, image string, r io.Reader, force bool) {
+	fmt.Printf("* Restoring volume %s\n", v.Name)
+	if force && dockerIsVolumePresent(v.Name) {
+		dockerRunCmd(dockerConstructCmd("volume", []string{"rm"}, shellQuote(v.Name)))
+	}
+
+	opts := []string{"create"}
+	for _, key := range slices.Sorted(maps.Keys(v.Labels)) {
+		opts = append(opts, "--label "+shellQuote(key+"="+v.Labels[key]))
+	}
+	dockerRunCmd(dockerConstructCmd("volume", opts, shellQuote(v.Name)))
+
+	opts = []string{
+		"--rm",
+		"--interactive",
+		"--user 0",
+		"--entrypoint tar",
+		"--volume " + shellQuote(v.Name+":/volume"),
+	}
+	if err := dockerRunPiped(dockerConstructCmd("run", opts, shellQuote(image), "-C /volume -xf -"), r, os.Stdout); err != nil {
+		log.Fatalf("ERROR: Failed to restore volume %s: %s", v.Name, err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().Bool("force", false, "Replace the existing config file and volumes, except global caches")
+}
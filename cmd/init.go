@@ -0,0 +1,173 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	initDefaultTemplate = "default"
+	initTemplateExt     = ".devsh"
+)
+
+//go:embed templates/*.devsh
+var initBuiltinTemplates embed.FS
+
+// initProjectTypes maps files that identify the type of a project to the
+// built-in template for it, in order of detection.
+var initProjectTypes = []struct {
+	file     string
+	template string
+}{
+	{"go.mod", "go"},
+	{"package.json", "node"},
+	{"pyproject.toml", "python"},
+	{"Cargo.toml", "rust"},
+	{"Gemfile", "ruby"},
+}
+
+// initTemplate is a template for a new .devsh file.
+type initTemplate struct {
+	source string // "built-in" or the path to a user-defined template
+	data   []byte
+}
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a .devsh file for the current project",
+	Long: `Create a .devsh config file for the project in the current folder.
+
+The type of the project is detected from the files in the folder (go.mod,
+package.json, pyproject.toml, Cargo.toml, Gemfile), and the .devsh file is
+written from the matching template with a proposed image, container folder,
+ports and cache volumes. Use --template to pick a template explicitly.
+
+Besides the built-in templates, user-defined templates are read from
+~/.config/devsh/templates (in that case the global config is read from
+~/.config/devsh/config). A template is a .devsh file named <name>.devsh; a
+user-defined template overrides the built-in template with the same name.
+
+For example:
+	devsh init # detects the project type
+	devsh init --template node
+	devsh init --list
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		templates := initTemplates()
+
+		if list, _ := cmd.Flags().GetBool("list"); list {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			for _, name := range slices.Sorted(maps.Keys(templates)) {
+				fmt.Fprintf(w, "%s\t%s\n", name, templates[name].source)
+			}
+			w.Flush()
+			return
+		}
+
+		name, _ := cmd.Flags().GetString("template")
+		if name == "" {
+			name = initDetectTemplate()
+		}
+		template, ok := templates[name]
+		if !ok {
+			log.Fatalf("ERROR: Template %s is not found, available templates: %s", name, strings.Join(slices.Sorted(maps.Keys(templates)), ", "))
+		}
+
+		// a template must be a valid config
+		var configValues ConfigValues
+		if err := yaml.Unmarshal(template.data, &configValues); err != nil {
+			log.Fatalf("ERROR: Failed to parse template %s (%s): %s", name, template.source, err)
+		}
+
+		force, _ := cmd.Flags().GetBool("force")
+		if _, err := os.Stat(configFilename); err == nil && !force {
+			log.Fatalf("ERROR: Config file %s already exists, use --force to overwrite it", configFilename)
+		}
+		if err := os.WriteFile(configFilename, template.data, 0o644); err != nil {
+			log.Fatalf("ERROR: Failed to write config file %s: %s", configFilename, err)
+		}
+
+		fmt.Printf("* Created %s from the %s template\n", configFilename, name)
+	},
+}
+
+// initDetectTemplate returns the name of the built-in template for the type of
+// the project in the current folder.
+func initDetectTemplate() string {
+	for _, t := range initProjectTypes {
+		if _, err := os.Stat(t.file); err == nil {
+			return t.template
+		}
+	}
+	return initDefaultTemplate
+}
+
+// initTemplates returns all available templates by name. User-defined
+// templates override the built-in ones.
+func initTemplates() map[string]initTemplate {
+	templates := map[string]initTemplate{}
+
+	entries, err := initBuiltinTemplates.ReadDir("templates")
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read built-in templates: %s", err)
+	}
+	for _, entry := range entries {
+		data, err := initBuiltinTemplates.ReadFile("templates/" + entry.Name())
+		if err != nil {
+			log.Fatalf("ERROR: Failed to read built-in template %s: %s", entry.Name(), err)
+		}
+		name := strings.TrimSuffix(entry.Name(), initTemplateExt)
+		templates[name] = initTemplate{source: "built-in", data: data}
+	}
+
+	dir := initUserTemplatesDir()
+	entries, err = os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return templates
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read templates folder %s: %s", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != initTemplateExt {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("ERROR: Failed to read template %s: %s", path, err)
+		}
+		name := strings.TrimSuffix(entry.Name(), initTemplateExt)
+		templates[name] = initTemplate{source: path, data: data}
+	}
+
+	return templates
+}
+
+// initUserTemplatesDir returns the folder with the user-defined templates.
+func initUserTemplatesDir() string {
+	return filepath.Join(configUserDir(), "templates")
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().StringP("template", "t", "", "Template to create the .devsh file from (default: detected from the project type)")
+	initCmd.Flags().BoolP("force", "f", false, "Overwrite an existing .devsh file")
+	initCmd.Flags().Bool("list", false, "List the available templates")
+}
@@ -0,0 +1,231 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+// configVarLookup resolves the value of a variable referenced in the config.
+// The second return value is false when the variable is not defined.
+type configVarLookup func(name string) (string, bool)
+
+// configInterpolate expands variable references in every string value of the
+// config (including list items). The following forms are supported:
+//
+//	${VAR}          value of the variable, an error if it is not defined
+//	${VAR:-default} value of the variable, or default if it is unset or empty
+//	$$              a literal '$'
+//
+// Besides environment variables, devsh provides the project.name, project.dir,
+// project.hash and git.branch variables. The project name itself may only
+// refer to environment variables.
+func configInterpolate(cfg ConfigValues) (ConfigValues, error) {
+	name, err := interpolateString(cfg.Name, configVarsLookup(nil))
+	if err != nil {
+		return cfg, fmt.Errorf("name: %w", err)
+	}
+	cfg.Name = name
+
+	v := reflect.ValueOf(&cfg).Elem()
+	if err := interpolateValue(v, "", configVarsLookup(&cfg)); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// configVarsLookup returns a lookup for the variables available in the config:
+// the devsh-provided ones and the environment. The project.* variables that
+// depend on the config are only defined when cfg is not nil.
+func configVarsLookup(cfg *ConfigValues) configVarLookup {
+	return func(name string) (string, bool) {
+		switch name {
+		case "project.name":
+			if cfg == nil {
+				return "", false
+			}
+			return cfg.Name, true
+		case "project.dir":
+			return configProjectDir(), true
+		case "project.hash":
+			return configProjectPathHash(), true
+		case "git.branch":
+			return configGitBranch()
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// configGitBranch returns the current git branch of the project folder. The
+// second return value is false if the folder is not a git repository or HEAD
+// is detached.
+func configGitBranch() (string, bool) {
+	out, err := exec.Command("git", "-C", configProjectDir(), "symbolic-ref", "--short", "-q", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// interpolateValue walks v recursively and expands variables in all strings it
+// finds. path is the yaml key path of v, used in error messages.
+func interpolateValue(v reflect.Value, path string, lookup configVarLookup) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := interpolateString(v.String(), lookup)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		v.SetString(s)
+
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return interpolateValue(v.Elem(), path, lookup)
+		}
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		// copy the slice so that the values of the source layers are left intact
+		items := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(items, v)
+		for i := 0; i < items.Len(); i++ {
+			if err := interpolateValue(items.Index(i), fmt.Sprintf("%s[%d]", path, i), lookup); err != nil {
+				return err
+			}
+		}
+		v.Set(items)
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		entries := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			item := reflect.New(iter.Value().Type()).Elem()
+			item.Set(iter.Value())
+			if err := interpolateValue(item, fmt.Sprintf("%s.%v", path, iter.Key()), lookup); err != nil {
+				return err
+			}
+			entries.SetMapIndex(iter.Key(), item)
+		}
+		v.Set(entries)
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key := yamlKey(field)
+			if path != "" {
+				key = path + "." + key
+			}
+			if err := interpolateValue(v.Field(i), key, lookup); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// yamlKey returns the yaml key of a struct field.
+func yamlKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// interpolateString expands variable references in s, see configInterpolate.
+func interpolateString(s string, lookup configVarLookup) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			out.WriteByte('$')
+			i++
+		case '{':
+			end := interpolateClosingBrace(s, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			value, err := interpolateVar(s[i+2:end], lookup)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			i = end
+		default:
+			out.WriteByte('$')
+		}
+	}
+
+	return out.String(), nil
+}
+
+// interpolateEscape escapes every '$' in s, so that a value taken literally,
+// e.g. from the host environment, is not expanded again.
+func interpolateEscape(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// interpolateClosingBrace returns the index of the '}' that closes a variable
+// reference starting at start, taking nested references in defaults into
+// account. It returns -1 if the reference is not terminated.
+func interpolateClosingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// interpolateVar resolves the body of a single ${...} reference.
+func interpolateVar(ref string, lookup configVarLookup) (string, error) {
+	name, def, hasDefault := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty variable name in ${%s}", ref)
+	}
+	for _, c := range name {
+		if !(c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return "", fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+
+	value, ok := lookup(name)
+	if hasDefault && value == "" {
+		return interpolateString(def, lookup)
+	}
+	if !ok {
+		return "", fmt.Errorf("undefined variable %s (write $${%s} to leave it to the shell in the container)", name, name)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const lockFilename = ".devsh.lock"
+
+// lockFile pins the images of the dev container and its services to digests,
+// so that everyone working on the project gets the same images.
+type lockFile struct {
+	// Images maps the images as configured to the same images pinned by
+	// digest, e.g. dev-go:latest to dev-go@sha256:...
+	Images map[string]string `yaml:"images"`
+}
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Pin the images of the dev container by digest",
+	Long: `Resolve the image of the dev container and the images of its services to
+their digests, and write them to the .devsh.lock file in the project folder.
+Commit the file, so that everyone working on the project gets the same images:
+new containers are created from the locked digests instead of the tags, which
+may point to different images on different machines.
+
+Images already in the lock file are kept; use --update to resolve them to the
+latest digests (the images are pulled). Images built from a Dockerfile (see the
+build key) are not locked.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := startContainerConfig(cmd)
+		update, _ := cmd.Flags().GetBool("update")
+
+		lock := lockLoad()
+		images := map[string]string{}
+		changed := false
+		for _, image := range lockImages(cfg) {
+			if pinned, ok := lock.Images[image]; ok && !update {
+				images[image] = pinned
+				continue
+			}
+			pinned, err := lockResolve(image)
+			if err != nil {
+				log.Fatalf("ERROR: Failed to lock image %s: %s", image, err)
+			}
+			if lock.Images[image] != pinned {
+				fmt.Printf("* Locked %s to %s\n", image, pinned)
+				changed = true
+			}
+			images[image] = pinned
+		}
+		if !changed && len(images) == len(lock.Images) {
+			fmt.Printf("* %s is up to date\n", lockFilename)
+			return
+		}
+		lock.Images = images
+		lockSave(lock)
+	},
+}
+
+// lockPath returns the path of the lock file of the project.
+func lockPath() string {
+	return filepath.Join(configProjectDir(), lockFilename)
+}
+
+// lockLoad reads the lock file of the project. A project without a lock file
+// has no locked images.
+func lockLoad() lockFile {
+	var lock lockFile
+	path := lockPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return lock
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read lock file %s: %s", path, err)
+	}
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		log.Fatalf("ERROR: Failed to parse lock file %s: %s", path, err)
+	}
+	return lock
+}
+
+// lockSave writes the lock file of the project.
+func lockSave(lock lockFile) {
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to serialize lock file: %s", err)
+	}
+	data = append([]byte("# Generated by devsh lock, do not edit.\n"), data...)
+	path := lockPath()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatalf("ERROR: Failed to write lock file %s: %s", path, err)
+	}
+}
+
+// lockImages returns the images of the dev container and its services that
+// can be locked, i.e. are not built by devsh nor pinned by digest already.
+func lockImages(cfg ConfigValues) []string {
+	var images []string
+	if cfg.Build == nil {
+		images = append(images, cfg.Image)
+	}
+	for _, svc := range cfg.Services {
+		images = append(images, svc.Image)
+	}
+	images = slices.DeleteFunc(images, func(image string) bool {
+		return image == "" || strings.Contains(image, "@")
+	})
+	slices.Sort(images)
+	return slices.Compact(images)
+}
+
+// lockResolve pulls an image and returns it pinned by the digest it has in
+// its registry.
+func lockResolve(image string) (string, error) {
+	if err := pullImage(image); err != nil {
+		return "", err
+	}
+	opts := []string{
+		"inspect",
+		"-f " + shellQuote(`{{join .RepoDigests " "}}`),
+	}
+	digests := strings.Fields(dockerRunCmd(dockerConstructCmd("image", opts, shellQuote(image))))
+	if len(digests) == 0 {
+		return "", errors.New("the image has no digest, it was not pulled from a registry")
+	}
+
+	// an image pulled under several names has a digest for each of them
+	repo := lockRepository(image)
+	for _, digest := range digests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return digest, nil
+		}
+	}
+	_, digest, _ := strings.Cut(digests[0], "@")
+	return repo + "@" + digest, nil
+}
+
+// lockRepository returns the repository of an image, i.e. the image without
+// its tag, e.g. registry:5000/dev-go for registry:5000/dev-go:latest.
+func lockRepository(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+// lockApply replaces the images of the dev container and its services with
+// the ones pinned in the lock file of the project, and warns about the images
+// missing from it.
+func lockApply(cfg ConfigValues) ConfigValues {
+	lock := lockLoad()
+	if lock.Images == nil {
+		return cfg
+	}
+
+	var unlocked []string
+	pin := func(image string) string {
+		pinned, ok := lock.Images[image]
+		if !ok {
+			unlocked = append(unlocked, image)
+			return image
+		}
+		return pinned
+	}
+
+	if cfg.Build == nil && !strings.Contains(cfg.Image, "@") {
+		cfg.Image = pin(cfg.Image)
+	}
+	services := make(map[string]ServiceConfig, len(cfg.Services))
+	for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+		svc := cfg.Services[name]
+		if !strings.Contains(svc.Image, "@") {
+			svc.Image = pin(svc.Image)
+		}
+		services[name] = svc
+	}
+	cfg.Services = services
+
+	if len(unlocked) > 0 {
+		log.Printf("WARN: Images not in %s: %s, run `devsh lock` to lock them", lockFilename, strings.Join(unlocked, ", "))
+	}
+	return cfg
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+
+	lockCmd.Flags().Bool("update", false, "Resolve all images to their latest digests")
+}
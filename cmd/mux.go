@@ -0,0 +1,271 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Persistent sessions are shells inside the dev container that keep running
+// when the terminal attached to them is closed. They are held by a terminal
+// multiplexer in the container: tmux or screen when one of them is installed,
+// otherwise by devsh itself (see mux_holder_linux.go).
+const (
+	muxTmux   = "tmux"
+	muxScreen = "screen"
+	muxHolder = "devsh"
+
+	// folder inside the dev container with the devsh binary that holds the
+	// sessions, and the folder with the sockets of its sessions
+	muxHolderDir        = "/tmp/.devsh"
+	muxHolderSocketsDir = muxHolderDir + "/sessions"
+)
+
+// muxSessionNameRe matches the valid names of persistent sessions.
+var muxSessionNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// muxArchs maps the machine names reported by uname to the Go architectures.
+var muxArchs = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+}
+
+// muxListScript prints the persistent sessions of every multiplexer available
+// in the dev container, one per line, prefixed with the multiplexer.
+const muxListScript = `
+if command -v tmux >/dev/null 2>&1; then
+	tmux list-sessions -F 'tmux|#{session_name}|#{session_created}|#{session_attached}' 2>/dev/null
+fi
+if command -v screen >/dev/null 2>&1; then
+	screen -ls 2>/dev/null | sed 's/^/screen|/'
+fi
+for b in ` + muxHolderDir + `/devsh-*; do
+	[ -x "$b" ] && "$b" session-holder ls && break
+done
+true`
+
+// muxDetectScript prints the multiplexer installed in the dev container, if
+// any.
+const muxDetectScript = `
+if command -v tmux >/dev/null 2>&1; then
+	echo tmux
+elif command -v screen >/dev/null 2>&1; then
+	echo screen
+fi`
+
+// muxSession is a persistent session in the dev container.
+type muxSession struct {
+	name     string
+	backend  string
+	created  time.Time // zero if not known
+	attached int       // number of attached terminals
+}
+
+// muxList returns the persistent sessions of the dev container. It returns no
+// sessions if the dev container does not exist or is not running.
+func muxList(cfg ConfigValues) []muxSession {
+	if !dockerIsContainerPresent(cfg.ContainerName) || !dockerIsContainerRunning(cfg.ContainerName) {
+		return nil
+	}
+	listCmd := dockerConstructCmd("exec", openExecOpts(cfg), cfg.ContainerName, "/bin/sh -c", shellQuote(muxListScript))
+	out := dockerRunCmd(listCmd)
+
+	var sessions []muxSession
+	for _, line := range strings.Split(out, "\n") {
+		backend, rest, ok := strings.Cut(line, "|")
+		if !ok {
+			continue
+		}
+		if backend == muxScreen {
+			// e.g. "\t1234.build\t(10/19/2026 10:00:00 AM)\t(Detached)"
+			fields := strings.Split(strings.TrimSpace(rest), "\t")
+			_, name, ok := strings.Cut(fields[0], ".")
+			if len(fields) < 2 || !ok {
+				continue
+			}
+			s := muxSession{name: name, backend: backend}
+			if fields[len(fields)-1] == "(Attached)" {
+				s.attached = 1
+			}
+			sessions = append(sessions, s)
+			continue
+		}
+
+		fields := strings.Split(rest, "|")
+		if len(fields) != 3 {
+			continue
+		}
+		s := muxSession{name: fields[0], backend: backend}
+		if created, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			s.created = time.Unix(created, 0)
+		}
+		s.attached, _ = strconv.Atoi(fields[2])
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// muxOpen attaches the terminal to the persistent session with the given name,
+// creating the session first if it does not exist and create is set. The
+// session keeps running when the terminal is detached or closed. If record is
+// set, the terminal is recorded to that file while it is attached.
+func muxOpen(cfg ConfigValues, name string, create bool, record string) {
+	if !muxSessionNameRe.MatchString(name) {
+		log.Fatalf("ERROR: Invalid session name %s, only letters, digits, '_', '.' and '-' are allowed", name)
+	}
+	if !dockerIsContainerPresent(cfg.ContainerName) {
+		log.Fatalf("ERROR: The dev container %s does not exist, start it first", cfg.ContainerName)
+	}
+	if !dockerIsContainerRunning(cfg.ContainerName) {
+		log.Fatalf("ERROR: The dev container %s is not running, start it first", cfg.ContainerName)
+	}
+
+	backend := ""
+	for _, s := range muxList(cfg) {
+		if s.name == name {
+			backend = s.backend
+			break
+		}
+	}
+	if backend == "" && !create {
+		log.Fatalf("ERROR: Session %s is not found in the dev container %s, see `devsh sessions`", name, cfg.ContainerName)
+	}
+
+	var args []string
+	if backend != "" {
+		fmt.Printf("* Attaching to session %s (%s)\n", name, backend)
+		args = muxAttachArgs(cfg, backend, name)
+	} else {
+		backend = muxBackend(cfg)
+		fmt.Printf("* Starting session %s (%s)\n", name, backend)
+		args = muxCreateArgs(cfg, backend, name)
+	}
+
+	opts := []string{
+		"-ti",
+	}
+	opts = append(opts, openExecOpts(cfg)...)
+	attachCmd := dockerConstructCmd("exec", opts, append([]string{cfg.ContainerName}, args...)...)
+
+	sshAgentCheck(cfg)
+	sessionEnd := sessionBegin(cfg, "session "+name)
+	err := openRunInteractive(cfg, attachCmd, record)
+	sessionEnd()
+	if err != nil {
+		log.Fatalf("WARN: Session %s exited with an error: %s", name, err)
+	}
+}
+
+// muxBackend returns the multiplexer that holds new persistent sessions in the
+// dev container.
+func muxBackend(cfg ConfigValues) string {
+	detectCmd := dockerConstructCmd("exec", openExecOpts(cfg), cfg.ContainerName, "/bin/sh -c", shellQuote(muxDetectScript))
+	if backend := dockerRunCmd(detectCmd); backend != "" {
+		return backend
+	}
+	return muxHolder
+}
+
+// muxCreateArgs returns the command that creates a persistent session running
+// the shell and attaches to it.
+func muxCreateArgs(cfg ConfigValues, backend, name string) []string {
+	shell := []string{"/bin/sh", "-c", shellQuote("exec " + cfg.ShellCmd)}
+	switch backend {
+	case muxTmux:
+		return []string{"tmux new-session -s", shellQuote(name), shellQuote(cfg.ShellCmd)}
+	case muxScreen:
+		return append([]string{"screen -S", shellQuote(name)}, shell...)
+	}
+	return append([]string{muxHolderInstall(cfg), "session-holder attach --create", shellQuote(name), "--"}, shell...)
+}
+
+// muxAttachArgs returns the command that attaches to an existing persistent
+// session.
+func muxAttachArgs(cfg ConfigValues, backend, name string) []string {
+	switch backend {
+	case muxTmux:
+		return []string{"tmux attach-session -t", shellQuote("=" + name)}
+	case muxScreen:
+		return []string{"screen -x", shellQuote(name)}
+	}
+	return []string{muxHolderInstall(cfg), "session-holder attach", shellQuote(name)}
+}
+
+// muxHolderInstall copies the devsh binary into the dev container, so that it
+// can hold the persistent sessions, and returns its path inside the container.
+// The binary is named after its checksum, so that a different devsh build gets
+// copied again. This only works if the host runs the same OS and architecture
+// as the dev container.
+func muxHolderInstall(cfg ConfigValues) string {
+	const hint = "install tmux or screen into the dev container to use persistent sessions"
+	if runtime.GOOS != "linux" {
+		log.Fatalf("ERROR: Neither tmux nor screen is found in the dev container %s, and devsh can only hold the sessions itself when the host runs linux (this host runs %s): %s", cfg.ContainerName, runtime.GOOS, hint)
+	}
+	archCmd := dockerConstructCmd("exec", nil, cfg.ContainerName, "uname -m")
+	if arch := dockerRunCmd(archCmd); muxArchs[arch] != runtime.GOARCH {
+		log.Fatalf("ERROR: Neither tmux nor screen is found in the dev container %s, and devsh can only hold the sessions itself when the dev container has the architecture of the host (the dev container is %s, the host %s): %s", cfg.ContainerName, arch, runtime.GOARCH, hint)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("ERROR: Failed to locate the devsh binary: %s", err)
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read the devsh binary: %s", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		log.Fatalf("ERROR: Failed to read the devsh binary: %s", err)
+	}
+	bin := fmt.Sprintf("%s/devsh-%x", muxHolderDir, h.Sum(nil)[:6])
+
+	testCmd := dockerConstructCmd("exec", nil, cfg.ContainerName, "/bin/sh -c", shellQuote("test -x "+bin+" && echo present || true"))
+	if dockerRunCmd(testCmd) == "present" {
+		return bin
+	}
+
+	mkdirScript := fmt.Sprintf("mkdir -p %s && chmod 1777 %s", muxHolderSocketsDir, muxHolderSocketsDir)
+	mkdirCmd := dockerConstructCmd("exec", []string{"--user root"}, cfg.ContainerName, "/bin/sh -c", shellQuote(mkdirScript))
+	dockerRunCmd(mkdirCmd)
+	cpCmd := dockerConstructCmd("cp", nil, shellQuote(exe), shellQuote(cfg.ContainerName+":"+bin))
+	dockerRunCmd(cpCmd)
+
+	return bin
+}
+
+// muxDisplay prints the persistent sessions of the dev container.
+func muxDisplay(sessions []muxSession) {
+	if len(sessions) == 0 {
+		return
+	}
+
+	fmt.Printf("* %d persistent session(s):\n", len(sessions))
+	for _, s := range sessions {
+		fmt.Printf("  - %s (%s), %s\n", s.name, s.backend, muxDescribe(s))
+	}
+}
+
+// muxDescribe returns a short description of the state of a persistent
+// session, e.g. "created 5m0s ago, detached".
+func muxDescribe(s muxSession) string {
+	state := "detached"
+	if s.attached > 0 {
+		state = fmt.Sprintf("attached (%d)", s.attached)
+	}
+	if s.created.IsZero() {
+		return state
+	}
+	return fmt.Sprintf("created %s ago, %s", time.Since(s.created).Round(time.Second), state)
+}
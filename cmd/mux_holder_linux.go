@@ -0,0 +1,466 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// The session holder runs inside the dev container when neither tmux nor
+// screen is installed. A holder process per session runs the shell in a
+// pseudo-terminal and listens on a unix socket in muxHolderSocketsDir, where
+// clients attach to it. Clients send framed messages (a type byte and a
+// length-prefixed payload); the holder streams the raw terminal output back.
+const (
+	muxHolderMsgAttach = 'a' // first message of a client that attaches
+	muxHolderMsgInfo   = 'i' // first message of a client that queries the session
+	muxHolderMsgData   = 'd' // input for the terminal
+	muxHolderMsgResize = 'r' // new terminal size, as rows and columns
+
+	// key that detaches the client from the session (Ctrl-\)
+	muxHolderDetachKey = 0x1c
+
+	// amount of recent terminal output replayed to attaching clients
+	muxHolderScrollback = 64 * 1024
+
+	// chunks of terminal output queued for a client, and the time a write to
+	// a client may take; a client that does not keep up (e.g. a suspended ssh
+	// session) is disconnected, so that it does not hold up the session
+	muxHolderClientQueue  = 256
+	muxHolderWriteTimeout = 5 * time.Second
+)
+
+// muxHolderCmd represents the hidden session-holder command
+var muxHolderCmd = &cobra.Command{
+	Use:    "session-holder",
+	Short:  "Hold persistent sessions inside a dev container",
+	Hidden: true,
+}
+
+// muxHolderServeCmd represents the session-holder serve command
+var muxHolderServeCmd = &cobra.Command{
+	Use:          "serve <name> -- <command>...",
+	SilenceUsage: true,
+	Short:        "Run a command in a persistent session",
+	Args:         cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rows, _ := cmd.Flags().GetUint16("rows")
+		cols, _ := cmd.Flags().GetUint16("cols")
+		return muxHolderServe(args[0], args[1:], rows, cols)
+	},
+}
+
+// muxHolderAttachCmd represents the session-holder attach command
+var muxHolderAttachCmd = &cobra.Command{
+	Use:          "attach <name> [-- <command>...]",
+	SilenceUsage: true,
+	Short:        "Attach the terminal to a persistent session",
+	Args:         cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		create, _ := cmd.Flags().GetBool("create")
+		if create && len(args) < 2 {
+			return errors.New("--create requires the command to run in the session")
+		}
+		return muxHolderAttach(args[0], args[1:], create)
+	},
+}
+
+// muxHolderLsCmd represents the session-holder ls command
+var muxHolderLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the persistent sessions",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		muxHolderList()
+	},
+}
+
+// muxHolderSocket returns the path of the socket of a session.
+func muxHolderSocket(name string) string {
+	return filepath.Join(muxHolderSocketsDir, name)
+}
+
+// muxHolderWrite writes a message to a session socket.
+func muxHolderWrite(w io.Writer, typ byte, payload []byte) error {
+	header := make([]byte, 5)
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// muxHolderRead reads a message from a session socket.
+func muxHolderRead(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// muxHolderSize encodes a terminal size as the payload of a resize message.
+func muxHolderSize(rows, cols uint16) []byte {
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, rows), cols)
+}
+
+// muxHolderServer is the state of a session holder process.
+type muxHolderServer struct {
+	pty     *os.File
+	created time.Time
+
+	mu         sync.Mutex
+	clients    map[net.Conn]chan []byte // output queued for each client
+	scrollback []byte
+	writers    sync.WaitGroup
+}
+
+// muxHolderServe runs a command in a new pseudo-terminal and holds it as a
+// session until the command exits.
+func muxHolderServe(name string, command []string, rows, cols uint16) error {
+	path := muxHolderSocket(name)
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("session %s already exists", name)
+	}
+	os.Remove(path) // left behind by a holder that was killed
+
+	// the session outlives the terminal it is started from
+	signal.Ignore(syscall.SIGHUP)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	defer ln.Close()
+
+	pty, tty, err := ptyOpen()
+	if err != nil {
+		return err
+	}
+	defer pty.Close()
+	if rows > 0 && cols > 0 {
+		termSetSize(pty.Fd(), rows, cols)
+	}
+	slave, err := os.OpenFile(tty, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+
+	s := &muxHolderServer{
+		pty:     pty,
+		created: time.Now(),
+		clients: map[net.Conn]chan []byte{},
+	}
+	go s.accept(ln)
+
+	// the terminal output ends once the command (and everything else using the
+	// terminal) exits
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := pty.Read(buf)
+		if n > 0 {
+			s.broadcast(buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	cmd.Wait()
+
+	// let the clients receive the remaining output
+	s.mu.Lock()
+	for conn := range s.clients {
+		s.remove(conn)
+	}
+	s.mu.Unlock()
+	s.writers.Wait()
+	return nil
+}
+
+// accept serves the clients connecting to the session socket.
+func (s *muxHolderServer) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve handles the messages of a client of the session.
+func (s *muxHolderServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	typ, _, err := muxHolderRead(conn)
+	if err != nil {
+		return
+	}
+	if typ == muxHolderMsgInfo {
+		s.mu.Lock()
+		fmt.Fprintf(conn, "%d|%d\n", s.created.Unix(), len(s.clients))
+		s.mu.Unlock()
+		return
+	}
+	if typ != muxHolderMsgAttach {
+		return
+	}
+
+	out := make(chan []byte, muxHolderClientQueue)
+	s.mu.Lock()
+	out <- slices.Clone(s.scrollback)
+	s.clients[conn] = out
+	s.writers.Add(1)
+	s.mu.Unlock()
+	go s.write(conn, out)
+	defer func() {
+		s.mu.Lock()
+		s.remove(conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		typ, payload, err := muxHolderRead(conn)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case muxHolderMsgData:
+			s.pty.Write(payload)
+		case muxHolderMsgResize:
+			if len(payload) == 4 {
+				termSetSize(s.pty.Fd(), binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:]))
+			}
+		}
+	}
+}
+
+// write sends the output queued for a client until the client is removed.
+func (s *muxHolderServer) write(conn net.Conn, out chan []byte) {
+	defer s.writers.Done()
+	defer conn.Close()
+	for p := range out {
+		conn.SetWriteDeadline(time.Now().Add(muxHolderWriteTimeout))
+		if _, err := conn.Write(p); err != nil {
+			// the client is gone or stalled, closing the connection ends
+			// its serve loop
+			conn.Close()
+			for range out {
+			}
+			return
+		}
+	}
+}
+
+// remove detaches a client from the session. Its writer sends the output
+// queued so far and closes the connection. s.mu must be held.
+func (s *muxHolderServer) remove(conn net.Conn) {
+	if out, ok := s.clients[conn]; ok {
+		close(out)
+		delete(s.clients, conn)
+	}
+}
+
+// broadcast queues terminal output for the attached clients and keeps it for
+// clients attaching later. It never blocks on a client: a client whose queue
+// is full is disconnected.
+func (s *muxHolderServer) broadcast(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scrollback = append(s.scrollback, p...)
+	if over := len(s.scrollback) - muxHolderScrollback; over > 0 {
+		s.scrollback = append([]byte(nil), s.scrollback[over:]...)
+	}
+	// p is reused for the next read from the terminal
+	chunk := slices.Clone(p)
+	for conn, out := range s.clients {
+		select {
+		case out <- chunk:
+		default:
+			conn.Close()
+			s.remove(conn)
+		}
+	}
+}
+
+// muxHolderAttach attaches the terminal to a session until the user detaches
+// with Ctrl-\ or the session ends. If create is set and the session does not
+// exist, a holder process running the command is started first.
+func muxHolderAttach(name string, command []string, create bool) error {
+	stdin := os.Stdin.Fd()
+	rows, cols, _ := termGetSize(os.Stdout.Fd())
+
+	path := muxHolderSocket(name)
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		if !create {
+			return fmt.Errorf("session %s is not found", name)
+		}
+		if conn, err = muxHolderStart(name, command, rows, cols); err != nil {
+			return err
+		}
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	send := func(typ byte, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return muxHolderWrite(conn, typ, payload)
+	}
+	if err := send(muxHolderMsgAttach, nil); err != nil {
+		return err
+	}
+
+	if termIsTerminal(stdin) {
+		state, err := termMakeRaw(stdin)
+		if err != nil {
+			return err
+		}
+		defer termRestore(stdin, state)
+	}
+
+	if rows > 0 && cols > 0 {
+		send(muxHolderMsgResize, muxHolderSize(rows, cols))
+	}
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			if rows, cols, err := termGetSize(os.Stdout.Fd()); err == nil {
+				send(muxHolderMsgResize, muxHolderSize(rows, cols))
+			}
+		}
+	}()
+
+	exited := make(chan bool)
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(exited)
+	}()
+	detached := make(chan bool)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if i := bytes.IndexByte(buf[:n], muxHolderDetachKey); i >= 0 {
+				send(muxHolderMsgData, buf[:i])
+				close(detached)
+				return
+			}
+			if n > 0 && send(muxHolderMsgData, buf[:n]) != nil {
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-exited:
+	case <-detached:
+		fmt.Printf("\r\n[detached from session %s]\r\n", name)
+	}
+	return nil
+}
+
+// muxHolderStart starts a detached holder process for a new session and
+// connects to it.
+func muxHolderStart(name string, command []string, rows, cols uint16) (net.Conn, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"session-holder", "serve",
+		"--rows", strconv.Itoa(int(rows)),
+		"--cols", strconv.Itoa(int(cols)),
+		name, "--",
+	}
+	cmd := exec.Command(exe, append(args, command...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	cmd.Process.Release()
+
+	path := muxHolderSocket(name)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("session %s did not start: %w", name, err)
+		}
+	}
+}
+
+// muxHolderList prints the sessions held by devsh in the format of muxList.
+// Sockets of holders that are gone are removed.
+func muxHolderList() {
+	entries, _ := os.ReadDir(muxHolderSocketsDir)
+	for _, entry := range entries {
+		path := muxHolderSocket(entry.Name())
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				os.Remove(path)
+			}
+			continue
+		}
+		var info string
+		if muxHolderWrite(conn, muxHolderMsgInfo, nil) == nil {
+			info, _ = bufio.NewReader(conn).ReadString('\n')
+		}
+		conn.Close()
+		if info != "" {
+			fmt.Printf("%s|%s|%s", muxHolder, entry.Name(), info)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(muxHolderCmd)
+	muxHolderCmd.AddCommand(muxHolderServeCmd, muxHolderAttachCmd, muxHolderLsCmd)
+
+	muxHolderServeCmd.Flags().Uint16("rows", 0, "Initial number of rows of the terminal")
+	muxHolderServeCmd.Flags().Uint16("cols", 0, "Initial number of columns of the terminal")
+	muxHolderAttachCmd.Flags().Bool("create", false, "Create the session if it does not exist")
+}
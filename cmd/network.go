@@ -0,0 +1,113 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+const (
+	// values of the network key that select a network managed by devsh: one
+	// network per project, or one network shared by all dev containers
+	networkProject = "project"
+	networkShared  = "shared"
+
+	// name of the network shared by all dev containers
+	networkSharedName = "devsh"
+)
+
+// networkBuiltin lists the networks that every docker engine provides. They
+// are never created or removed by devsh, and do not support DNS aliases.
+var networkBuiltin = []string{"bridge", "host", "none"}
+
+// networkName returns the name of the docker network of the dev container,
+// with the project and shared networks resolved to their names, e.g.
+// devsh-myproject-a1b2 for the project network.
+func networkName(cfg ConfigValues) string {
+	switch cfg.Network {
+	case networkProject:
+		return networkProjectName(cfg)
+	case networkShared:
+		return networkSharedName
+	}
+	return cfg.Network
+}
+
+// networkProjectName returns the name of the network of the project.
+func networkProjectName(cfg ConfigValues) string {
+	return "devsh-" + cfg.Name + "-" + configProjectPathHash()
+}
+
+// networkIsManaged returns true if the dev container is attached to a
+// user-defined network, i.e. not a built-in network or the network of another
+// container. Containers on such a network reach each other by name.
+func networkIsManaged(network string) bool {
+	return network != "" && !slices.Contains(networkBuiltin, network) && !strings.HasPrefix(network, "container:")
+}
+
+// networkCreate creates the network of the dev container if it does not exist
+// yet. The network is labelled, so that devsh removes it once it is no longer
+// used; networks created otherwise are left alone.
+func networkCreate(cfg ConfigValues) {
+	if !networkIsManaged(cfg.Network) || dockerIsNetworkPresent(cfg.Network) {
+		return
+	}
+
+	opts := []string{
+		"create",
+	}
+	switch cfg.Network {
+	case networkProjectName(cfg):
+		opts = append(opts, dockerLabelOpt("network", networkProject), dockerLabelOpt("project", configProjectDir()))
+	case networkSharedName:
+		opts = append(opts, dockerLabelOpt("network", networkShared))
+	default:
+		opts = append(opts, dockerLabelOpt("network", "named"))
+	}
+	dockerRunCmd(dockerConstructCmd("network", opts, shellQuote(cfg.Network)))
+	fmt.Printf("* Created network %s\n", cfg.Network)
+}
+
+// networkRunOpts returns the options that attach the dev container to its
+// network, where other containers can reach it by its host name.
+func networkRunOpts(cfg ConfigValues) []string {
+	if cfg.Network == "" {
+		return nil
+	}
+	opts := []string{
+		"--network " + shellQuote(cfg.Network),
+	}
+	if networkIsManaged(cfg.Network) {
+		opts = append(opts, "--network-alias "+shellQuote(cfg.ContainerHost))
+	}
+	return opts
+}
+
+// networkRemoveUnused removes the network of the dev container if it was
+// created by devsh, i.e. it has the devsh label, and no container is attached
+// to it anymore.
+func networkRemoveUnused(cfg ConfigValues) {
+	if !networkIsManaged(cfg.Network) || !dockerIsNetworkPresent(cfg.Network) {
+		return
+	}
+
+	opts := []string{
+		"inspect",
+		"-f " + shellQuote(`{{index .Labels "`+dockerLabelPrefix+`network"}}|{{len .Containers}}`),
+	}
+	out := dockerRunCmd(dockerConstructCmd("network", opts, shellQuote(cfg.Network)))
+	label, containers, _ := strings.Cut(out, "|")
+	if label == "" || containers != "0" {
+		return
+	}
+
+	rmCmd := dockerConstructCmd("network", []string{"rm"}, shellQuote(cfg.Network))
+	if err := dockerRunStreamed(rmCmd); err != nil {
+		log.Printf("WARN: Failed to remove network %s: %s", cfg.Network, err)
+		return
+	}
+	fmt.Printf("* Removed network %s\n", cfg.Network)
+}
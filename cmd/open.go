@@ -3,6 +3,9 @@
 package cmd
 
 import (
+	"fmt"
+	"log"
+
 	"github.com/spf13/cobra"
 )
 
@@ -12,25 +15,76 @@ var openCmd = &cobra.Command{
 	Short: "Open a shell in the dev container",
 	Long: `Open a shell in the development container.
 
+With --session, the shell runs in a persistent session with the given name,
+which keeps running when the terminal is closed or detached. If the session
+exists already, the terminal is attached to it. Sessions are held by tmux or
+screen when one of them is installed in the dev container, otherwise by devsh
+itself (detach with Ctrl-\). See also "devsh sessions" and "devsh attach".
+
+With --record, the terminal of the shell is recorded to a file in the
+asciicast v2 format, which can be played back with "devsh replay <file>" (or
+with asciinema).
+
+For example:
+	devsh open --session build
+	devsh open --record session.cast
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		cfg := configLoad(cmd)
-		openShell(cfg)
+		healthWaitReady(cfg)
+		dotfilesInstall(cfg)
+		record, _ := cmd.Flags().GetString("record")
+		if name, _ := cmd.Flags().GetString("session"); name != "" {
+			muxOpen(cfg, name, true, record)
+		} else {
+			openShell(cfg, record)
+		}
 		statusDisplay(cfg)
 	},
 }
 
-func openShell(cfg ConfigValues) {
+func openShell(cfg ConfigValues, record string) {
 	opts := []string{
 		"-ti",
 	}
+	opts = append(opts, openExecOpts(cfg)...)
 	shellCmd := dockerConstructCmd("exec", opts, cfg.ContainerName, cfg.ShellCmd)
-	dockerRunInteractive(shellCmd)
+
+	sshAgentCheck(cfg)
+	sessionEnd := sessionBegin(cfg, cfg.ShellCmd)
+	err := openRunInteractive(cfg, shellCmd, record)
+	sessionEnd()
+	if err != nil {
+		log.Fatalf("WARN: Shell exited with an error: %s", err)
+	}
+}
+
+// openRunInteractive runs an interactive command in the dev container, and
+// records its terminal to a file if record is set.
+func openRunInteractive(cfg ConfigValues, shellCmd string, record string) error {
+	if record == "" {
+		return dockerRunInteractive(shellCmd)
+	}
+	fmt.Printf("* Recording the session to %s\n", record)
+	return recordRunInteractive(shellCmd, record, recordHeader{Command: cfg.ShellCmd, Title: cfg.ContainerName})
+}
+
+// Returns the options common to every command executed in the dev container
+func openExecOpts(cfg ConfigValues) []string {
+	var opts []string
+	if cfg.User != "" {
+		opts = append(opts, "--user "+shellQuote(cfg.User))
+	}
+	opts = append(opts, sshAgentExecOpts(cfg)...)
+	return opts
 }
 
 func init() {
 	rootCmd.AddCommand(openCmd)
 
+	openCmd.Flags().String("session", "", "Open the shell in the persistent session with this name")
+	openCmd.Flags().String("record", "", "Record the session to this file (asciicast v2)")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
@@ -0,0 +1,282 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// host port of a published port that devsh picks for the dev container
+const portsAuto = "auto"
+
+// portsMapping is a port of the dev container published on the host, as given
+// in the ports config, e.g. 127.0.0.1:8080:80/tcp or [::1]:8080:80/tcp.
+type portsMapping struct {
+	ip            string // without the brackets of an IPv6 address
+	hostPort      string // a port number, "auto", a range or empty
+	containerPort string
+	proto         string
+}
+
+// portsParse parses a port given in the format of docker run --publish, where
+// the host port can also be "auto".
+func portsParse(spec string) (portsMapping, error) {
+	var m portsMapping
+	rest, proto, ok := strings.Cut(spec, "/")
+	m.proto = "tcp"
+	if ok {
+		m.proto = proto
+	}
+
+	// an IPv6 address is given in brackets, e.g. [::1]:8080:80
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]:")
+		if end < 0 || net.ParseIP(rest[1:end]) == nil {
+			return m, fmt.Errorf("invalid port %s, expected [ipv6]:host_port:container_port", spec)
+		}
+		m.ip = rest[1:end]
+		hostPort, containerPort, ok := strings.Cut(rest[end+2:], ":")
+		if !ok || strings.Contains(containerPort, ":") {
+			return m, fmt.Errorf("invalid port %s", spec)
+		}
+		m.hostPort, m.containerPort = hostPort, containerPort
+		if m.containerPort == "" {
+			return m, fmt.Errorf("invalid port %s, the container port is missing", spec)
+		}
+		return m, nil
+	}
+
+	parts := strings.Split(rest, ":")
+	switch len(parts) {
+	case 1:
+		m.containerPort = parts[0]
+	case 2:
+		m.hostPort, m.containerPort = parts[0], parts[1]
+	case 3:
+		m.ip, m.hostPort, m.containerPort = parts[0], parts[1], parts[2]
+	default:
+		return m, fmt.Errorf("invalid port %s", spec)
+	}
+	if m.containerPort == "" {
+		return m, fmt.Errorf("invalid port %s, the container port is missing", spec)
+	}
+	return m, nil
+}
+
+// String returns the mapping in the format of docker run --publish.
+func (m portsMapping) String() string {
+	s := m.containerPort + "/" + m.proto
+	if m.hostPort != "" || m.ip != "" {
+		s = m.hostPort + ":" + s
+	}
+	if strings.Contains(m.ip, ":") {
+		s = "[" + m.ip + "]:" + s
+	} else if m.ip != "" {
+		s = m.ip + ":" + s
+	}
+	return s
+}
+
+// portsResolver resolves the ports to publish for the containers of a
+// project: the dev container and its services.
+type portsResolver struct {
+	cfg   ConfigValues
+	state map[string]int  // host ports picked, by container and port as given
+	used  map[string]bool // host ports taken by the resolved ports
+}
+
+// portsNewResolver returns a resolver for the ports of the dev container and
+// its services, which prefers the host ports picked for the previous
+// containers of the project.
+func portsNewResolver(cfg ConfigValues) *portsResolver {
+	return &portsResolver{
+		cfg:   cfg,
+		state: portsLoadState(cfg),
+		used:  map[string]bool{},
+	}
+}
+
+// resolve returns the ports to publish for a container (the dev container if
+// service is empty). Host ports given as "auto" are replaced with free host
+// ports, preferably the ones picked for the previous container, or else the
+// container port. Fixed host ports are checked to be free, so that a conflict
+// is reported before anything is created.
+func (r *portsResolver) resolve(service string, specs []string) []string {
+	var ports []string
+	for _, spec := range specs {
+		m, err := portsParse(spec)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		what := "ports: " + spec
+		key := spec
+		if service != "" {
+			what = "service " + service + ", " + what
+			key = service + "/" + spec
+		}
+
+		switch {
+		case m.hostPort == portsAuto:
+			port := 0
+			candidates := []int{r.state[key]}
+			if p, err := strconv.Atoi(m.containerPort); err == nil {
+				candidates = append(candidates, p)
+			}
+			for _, p := range candidates {
+				if p > 0 && !r.used[m.proto+strconv.Itoa(p)] && portsIsFree(m.ip, strconv.Itoa(p), m.proto) {
+					port = p
+					break
+				}
+			}
+			if port == 0 {
+				if port, err = portsFree(m.ip, m.proto); err != nil {
+					log.Fatalf("ERROR: Failed to find a free host port for %s: %s", spec, err)
+				}
+			}
+			m.hostPort = strconv.Itoa(port)
+			r.state[key] = port
+		case m.hostPort != "" && !strings.Contains(m.hostPort, "-"):
+			if r.used[m.proto+m.hostPort] {
+				log.Fatalf("ERROR: Host port %s (%s) is published twice", m.hostPort, what)
+			}
+			portsCheckFree(m, what)
+		}
+
+		r.used[m.proto+m.hostPort] = true
+		ports = append(ports, m.String())
+	}
+	return ports
+}
+
+// save saves the host ports picked by the resolver in the state folder.
+func (r *portsResolver) save() {
+	if len(r.state) > 0 {
+		portsSaveState(r.cfg, r.state)
+	}
+}
+
+// portsCheckFree fails with an error naming the owner of the host port of the
+// mapping if the port is not free. what describes where the port is given.
+func portsCheckFree(m portsMapping, what string) {
+	if portsIsFree(m.ip, m.hostPort, m.proto) {
+		return
+	}
+	owner := "another process"
+	if name := portsContainer(m.hostPort, m.proto); name != "" {
+		owner = "the container " + name
+	}
+	log.Fatalf("ERROR: Host port %s (%s) is already in use by %s, free it or use auto:%s to pick a free host port",
+		m.hostPort, what, owner, m.containerPort)
+}
+
+// portsIsFree returns true if the host port can be bound.
+func portsIsFree(ip, port, proto string) bool {
+	addr := net.JoinHostPort(ip, port)
+	if proto == "udp" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	ln.Close()
+	return true
+}
+
+// portsFree returns a free host port picked by the OS.
+func portsFree(ip, proto string) (int, error) {
+	addr := net.JoinHostPort(ip, "0")
+	if proto == "udp" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// portsContainer returns the name of the running container that publishes the
+// host port, if any.
+func portsContainer(port, proto string) string {
+	opts := []string{
+		"--filter publish=" + shellQuote(port+"/"+proto),
+		"--format '{{.Names}}'",
+	}
+	names := dockerRunCmd(dockerConstructCmd("ps", opts))
+	name, _, _ := strings.Cut(names, "\n")
+	return name
+}
+
+// portsStatePath returns the file with the host ports picked for the dev
+// container and its services.
+func portsStatePath(cfg ConfigValues) string {
+	return filepath.Join(configStateDir(), "ports", cfg.ContainerName)
+}
+
+// portsLoadState returns the host ports picked for the previous containers of
+// the project, by the port given in the config (prefixed with the service).
+func portsLoadState(cfg ConfigValues) map[string]int {
+	state := map[string]int{}
+	path := portsStatePath(cfg)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read port state %s: %s", path, err)
+	}
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		log.Printf("WARN: Ignoring invalid port state %s: %s", path, err)
+	}
+	return state
+}
+
+// portsSaveState saves the host ports picked for the dev container and its
+// services.
+func portsSaveState(cfg ConfigValues, state map[string]int) {
+	path := portsStatePath(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Fatalf("ERROR: Failed to create state folder %s: %s", filepath.Dir(path), err)
+	}
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to serialize port state: %s", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatalf("ERROR: Failed to write port state %s: %s", path, err)
+	}
+}
+
+// portsDisplay prints the ports published by the running dev container.
+func portsDisplay(cfg ConfigValues) {
+	out := dockerRunCmd(dockerConstructCmd("port", nil, cfg.ContainerName))
+	if out == "" {
+		return
+	}
+
+	fmt.Println("* Published ports:")
+	for _, line := range strings.Split(out, "\n") {
+		fmt.Printf("  - %s\n", line)
+	}
+}
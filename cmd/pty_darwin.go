@@ -0,0 +1,35 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ptyOpen allocates a new pseudo-terminal and returns its master side along
+// with the path to its slave side.
+func ptyOpen() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := termIoctl(master.Fd(), syscall.TIOCPTYGRANT, nil); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	if err := termIoctl(master.Fd(), syscall.TIOCPTYUNLK, nil); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	name := make([]byte, 128)
+	if err := termIoctl(master.Fd(), syscall.TIOCPTYGNAME, unsafe.Pointer(&name[0])); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	return master, string(name[:bytes.IndexByte(name, 0)]), nil
+}
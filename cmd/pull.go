@@ -0,0 +1,180 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// values of the pull key: when to pull the image before a container is
+// created from it
+const (
+	pullAlways  = "always"
+	pullMissing = "missing"
+	pullNever   = "never"
+	pullDaily   = "daily"
+)
+
+// pullCmd represents the pull command
+var pullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Pull the latest image of the dev container",
+	Long: `Pull the latest version of the image of the dev container, and of the images
+of its services. An image built from a Dockerfile (see the build key) is
+rebuilt with the latest version of its base image instead.
+
+Images pinned in the lock file (see "devsh lock") are pulled by their locked
+digests; use "devsh lock --update" to move to the latest images.
+
+A dev container keeps running the image it was created with; restart it with
+"devsh stop" and "devsh start" to use the new image. "devsh status" reports
+when the dev container runs an outdated image.
+
+When the image is pulled on start depends on the pull key: missing (default)
+pulls it only if it is not present locally, always before every new dev
+container, daily if it was last pulled more than a day ago, and never fails
+instead of pulling it.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := lockApply(startContainerConfig(cmd))
+
+		if cfg.Build != nil {
+			startBuildImage(cfg, true)
+		} else if err := pullImage(cfg.Image); err != nil {
+			log.Fatalf("ERROR: Failed to pull image %s: %s", cfg.Image, err)
+		}
+		for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+			image := cfg.Services[name].Image
+			if err := pullImage(image); err != nil {
+				log.Fatalf("ERROR: Failed to pull image %s of the service %s: %s", image, name, err)
+			}
+		}
+
+		pullDisplayOutdated(cfg)
+	},
+}
+
+// pullPolicy returns the pull policy of the config.
+func pullPolicy(cfg ConfigValues) string {
+	switch cfg.Pull {
+	case "":
+		return pullMissing
+	case pullAlways, pullMissing, pullNever, pullDaily:
+		return cfg.Pull
+	}
+	log.Fatalf("ERROR: Invalid pull %s, expected %s, %s, %s or %s", cfg.Pull, pullAlways, pullMissing, pullNever, pullDaily)
+	return ""
+}
+
+// pullEnsure pulls an image according to the pull policy, before a container
+// is created from it. If pulling fails but the image is present locally, the
+// local image is used.
+func pullEnsure(cfg ConfigValues, image string) {
+	present := dockerIsImagePresent(image)
+	switch pullPolicy(cfg) {
+	case pullMissing:
+		if present {
+			return
+		}
+	case pullNever:
+		if !present {
+			log.Fatalf("ERROR: Image %s is not present locally and pull is %s, pull it with `devsh pull`", image, pullNever)
+		}
+		return
+	case pullDaily:
+		if last, ok := pullLoadState()[image]; present && ok && time.Since(last) < 24*time.Hour {
+			return
+		}
+	}
+
+	if err := pullImage(image); err != nil {
+		if !present {
+			log.Fatalf("ERROR: Failed to pull image %s: %s", image, err)
+		}
+		log.Printf("WARN: Failed to pull image %s, using the local image: %s", image, err)
+	}
+}
+
+// pullImage pulls an image, and records when it was pulled.
+func pullImage(image string) error {
+	fmt.Printf("* Pulling image %s\n", image)
+	if err := dockerRunStreamed(dockerConstructCmd("pull", nil, shellQuote(image))); err != nil {
+		return err
+	}
+
+	state := pullLoadState()
+	state[image] = time.Now()
+	pullSaveState(state)
+	return nil
+}
+
+// pullStatePath returns the file with the times the images were last pulled.
+func pullStatePath() string {
+	return filepath.Join(configStateDir(), "pulls")
+}
+
+// pullLoadState returns the times the images were last pulled, by image.
+func pullLoadState() map[string]time.Time {
+	state := map[string]time.Time{}
+	path := pullStatePath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read pull state %s: %s", path, err)
+	}
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		log.Printf("WARN: Ignoring invalid pull state %s: %s", path, err)
+	}
+	return state
+}
+
+// pullSaveState saves the times the images were last pulled.
+func pullSaveState(state map[string]time.Time) {
+	path := pullStatePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Fatalf("ERROR: Failed to create state folder %s: %s", filepath.Dir(path), err)
+	}
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to serialize pull state: %s", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatalf("ERROR: Failed to write pull state %s: %s", path, err)
+	}
+}
+
+// pullDisplayOutdated prints a note if the local image of the dev container
+// differs from the one the dev container was created with, e.g. after it was
+// pulled or rebuilt. Dev containers started from a snapshot are not checked.
+func pullDisplayOutdated(cfg ConfigValues) {
+	if !dockerIsContainerPresent(cfg.ContainerName) || strings.HasPrefix(dockerContainerImage(cfg.ContainerName), snapshotImagePrefix) {
+		return
+	}
+	image := cfg.Image
+	if pinned, ok := lockLoad().Images[image]; ok && cfg.Build == nil {
+		image = pinned
+	}
+	local := dockerImageId(image)
+	if local == "" || local == dockerContainerImageId(cfg.ContainerName) {
+		return
+	}
+	fmt.Printf("* Image %s has changed since the dev container was created, restart it with `devsh stop` and `devsh start` to use the new image\n", cfg.Image)
+}
+
+func init() {
+	rootCmd.AddCommand(pullCmd)
+}
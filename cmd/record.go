@@ -0,0 +1,172 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// Sessions are recorded in the asciicast v2 format of asciinema: a header line
+// followed by one line per event, see
+// https://docs.asciinema.org/manual/asciicast/v2/
+const recordVersion = 2
+
+// recordHeader is the header line of an asciicast v2 recording.
+type recordHeader struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Command       string            `json:"command,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes the output and resize events of a terminal to a recording.
+type recorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	started time.Time
+	partial []byte // incomplete UTF-8 sequence at the end of the last output
+}
+
+// recordNew starts a recording to w with the given header.
+func recordNew(w io.Writer, header recordHeader) (*recorder, error) {
+	r := &recorder{w: w, started: time.Now()}
+	header.Version = recordVersion
+	header.Timestamp = r.started.Unix()
+	if err := r.encode(header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write records terminal output. Output is recorded as text, so a UTF-8
+// sequence split between writes is held back until it is complete.
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.partial, p...)
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	r.partial = append([]byte(nil), data[end:]...)
+	if end == 0 {
+		return len(p), nil
+	}
+	return len(p), r.event("o", string(data[:end]))
+}
+
+// resize records a change of the terminal size.
+func (r *recorder) resize(rows, cols uint16) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// event writes an event line to the recording.
+func (r *recorder) event(kind, data string) error {
+	elapsed := float64(time.Since(r.started).Microseconds()) / 1e6
+	return r.encode([]any{elapsed, kind, data})
+}
+
+// encode writes a line of JSON to the recording.
+func (r *recorder) encode(v any) error {
+	enc := json.NewEncoder(r.w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// recordRunInteractive runs a shell command like dockerRunInteractive, and
+// records its terminal to a file in the asciicast format. The command runs in
+// a pseudo-terminal of its own, which devsh relays to the user's terminal.
+func recordRunInteractive(shellCommand string, path string, header recordHeader) error {
+	stdin := os.Stdin.Fd()
+	if !termIsTerminal(stdin) {
+		return errors.New("recording requires a terminal")
+	}
+	rows, cols, err := termGetSize(os.Stdout.Fd())
+	if err != nil || rows == 0 || cols == 0 {
+		rows, cols = 24, 80
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header.Width, header.Height = int(cols), int(rows)
+	header.Env = map[string]string{"TERM": os.Getenv("TERM")}
+	rec, err := recordNew(f, header)
+	if err != nil {
+		return err
+	}
+
+	pty, tty, err := ptyOpen()
+	if err != nil {
+		return err
+	}
+	defer pty.Close()
+	termSetSize(pty.Fd(), rows, cols)
+	slave, err := os.OpenFile(tty, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+
+	if globalFlagVerbose {
+		fmt.Println("+ " + shellCommand) // if echo/verbose
+	}
+	cmd := exec.Command("/bin/sh", "-c", shellCommand)
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+
+	state, err := termMakeRaw(stdin)
+	if err != nil {
+		return err
+	}
+	defer termRestore(stdin, state)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			if rows, cols, err := termGetSize(os.Stdout.Fd()); err == nil {
+				termSetSize(pty.Fd(), rows, cols)
+				rec.resize(rows, cols)
+			}
+		}
+	}()
+
+	go io.Copy(pty, os.Stdin)
+	// the output ends with an error once the command exits and the
+	// pseudo-terminal is closed
+	io.Copy(io.MultiWriter(os.Stdout, rec), pty)
+
+	return cmd.Wait()
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay <file>",
+	Short: "Play back a recorded shell session",
+	Long: `Play back a shell session recorded with "devsh open --record <file>" in the
+terminal, with its original timing. Recordings are in the asciicast v2 format,
+so they can also be played back with asciinema.
+
+For example:
+	devsh replay session.cast
+	devsh replay --speed 2 --idle-limit 1 session.cast
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		speed, _ := cmd.Flags().GetFloat64("speed")
+		idleLimit, _ := cmd.Flags().GetFloat64("idle-limit")
+		if speed <= 0 {
+			log.Fatalf("ERROR: Invalid speed %v, expected a positive number", speed)
+		}
+
+		path := args[0]
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("ERROR: Failed to open recording: %s", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 16*1024*1024)
+		if !scanner.Scan() {
+			log.Fatalf("ERROR: Recording %s is empty", path)
+		}
+		var header recordHeader
+		if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != recordVersion {
+			log.Fatalf("ERROR: %s is not an asciicast v%d recording", path, recordVersion)
+		}
+		if idleLimit == 0 {
+			idleLimit = header.IdleTimeLimit
+		}
+		if rows, cols, err := termGetSize(os.Stdout.Fd()); err == nil && (int(cols) < header.Width || int(rows) < header.Height) {
+			log.Printf("WARN: The recording is %dx%d, larger than the terminal (%dx%d)", header.Width, header.Height, cols, rows)
+		}
+
+		last := 0.0
+		for line := 1; scanner.Scan(); line++ {
+			var event []any
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+				log.Fatalf("ERROR: Invalid event on line %d of %s", line+1, path)
+			}
+			t, _ := event[0].(float64)
+			kind, _ := event[1].(string)
+			data, _ := event[2].(string)
+
+			delay := t - last
+			if idleLimit > 0 && delay > idleLimit {
+				delay = idleLimit
+			}
+			time.Sleep(time.Duration(delay / speed * float64(time.Second)))
+			last = t
+
+			// resize events cannot be applied to the terminal, and input
+			// events are already echoed in the output
+			if kind == "o" {
+				os.Stdout.WriteString(data)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatalf("ERROR: Failed to read recording: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().Float64("speed", 1, "Playback speed factor")
+	replayCmd.Flags().Float64("idle-limit", 0, "Limit pauses to this many seconds (default: as recorded, or no limit)")
+}
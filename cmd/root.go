@@ -34,11 +34,13 @@ Or:
 
 		// start the dev container if it is not started yet
 		if !(dockerIsContainerPresent(cfg.ContainerName) && dockerIsContainerRunning(cfg.ContainerName)) {
-			dockerCmd := startDockerCmd(cfg)
-			dockerRunCmd(dockerCmd)
+			startContainer(cfg)
+		} else {
+			healthWaitReady(cfg)
+			dotfilesInstall(cfg)
 		}
 
-		openShell(cfg)
+		openShell(cfg, "")
 
 		statusDisplay(cfg)
 	},
@@ -65,15 +67,27 @@ func init() {
 	// highest priority, overriding values from the global and project config
 	// files. They are persistent so they apply to every subcommand.
 	rootCmd.PersistentFlags().StringP("image", "i", "", "Docker image for the dev container")
+	rootCmd.PersistentFlags().String("pull", "", "When to pull the images: missing (default), always, daily or never")
+	rootCmd.PersistentFlags().String("snapshot", "", "Start from a snapshot of the dev container instead of the image: latest or its tag")
 	rootCmd.PersistentFlags().StringP("name", "n", "", "Name of the project")
 	rootCmd.PersistentFlags().StringP("shell-cmd", "s", "", "Shell to start inside the dev container (e.g. /bin/bash)")
 	rootCmd.PersistentFlags().String("container-host", "", "Hostname for the dev container")
 	rootCmd.PersistentFlags().String("container-dir", "", "Path inside the dev container where the project is mounted")
 	rootCmd.PersistentFlags().String("container-name", "", "Human-readable name for the dev container")
-	rootCmd.PersistentFlags().StringSliceP("ports", "p", nil, "Ports of the container exposed on the host")
+	rootCmd.PersistentFlags().StringSliceP("ports", "p", nil, "Ports of the container exposed on the host (auto:<port> picks a free host port)")
 	rootCmd.PersistentFlags().StringSliceP("volumes", "V", nil, "Additional volumes to be mounted inside the dev container")
-	rootCmd.PersistentFlags().String("network", "", "Docker network for the dev container")
+	rootCmd.PersistentFlags().String("network", "", "Docker network for the dev container (project or shared for a network managed by devsh)")
 	rootCmd.PersistentFlags().String("dns", "", "Explicit DNS server to use for the dev container")
+	rootCmd.PersistentFlags().StringArrayP("env", "e", nil, "Environment variables set in the dev container (KEY=VALUE)")
+	rootCmd.PersistentFlags().StringP("user", "u", "", "User to run the shell as inside the dev container")
+	rootCmd.PersistentFlags().String("post-create-cmd", "", "Command run inside the dev container once it is created")
+	rootCmd.PersistentFlags().String("wait-timeout", "", "How long to wait for the dev container and its services to be ready (e.g. 2m)")
+	rootCmd.PersistentFlags().StringSlice("caches", nil, "Toolchain caches kept in named volumes (presets: go, node, python, rust, ruby, or <name>:<path>)")
+	rootCmd.PersistentFlags().String("cache-scope", "", "Scope of the cache volumes: global (shared between projects) or project")
+	rootCmd.PersistentFlags().Bool("persist-home", false, "Keep the home folder of the container user in a per-project volume")
+	rootCmd.PersistentFlags().Bool("ssh-agent", false, "Forward the SSH agent of the host into the dev container")
+	rootCmd.PersistentFlags().Bool("git-config", false, "Forward the git identity and safe git settings of the host into the dev container")
+	rootCmd.PersistentFlags().String("profile", "", "Profile from the config to use (overrides DEVSH_PROFILE)")
 
 	rootCmd.SetVersionTemplate(VERSION_TEMPLATE)
 }
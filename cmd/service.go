@@ -0,0 +1,198 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ServiceConfig describes a service container started alongside the dev
+// container, e.g. a database used during development.
+type ServiceConfig struct {
+	Image       string             `yaml:"image,omitempty"`
+	Env         map[string]string  `yaml:"env,omitempty"`
+	Ports       []string           `yaml:"ports,omitempty"`
+	Volumes     []string           `yaml:"volumes,omitempty"`
+	DependsOn   map[string]string  `yaml:"depends_on,omitempty"`
+	Healthcheck *HealthcheckConfig `yaml:"healthcheck,omitempty"`
+}
+
+// serviceContainerName returns the name of the container of a service, e.g.
+// myproject-a1b2-db.
+func serviceContainerName(cfg ConfigValues, name string) string {
+	return cfg.ContainerName + "-" + name
+}
+
+// serviceAlias returns the name the dev container reaches a service by. On
+// the network of the project, it is the name of the service, e.g. db. Other
+// networks may be shared with other projects, which may have services with
+// the same name, so the name is qualified with the host name of the dev
+// container, e.g. db.myproject.
+func serviceAlias(cfg ConfigValues, name string) string {
+	if cfg.Network == networkProjectName(cfg) {
+		return name
+	}
+	return name + "." + cfg.ContainerHost
+}
+
+// servicePorts resolves the ports to publish for the services whose container
+// is to be created.
+func servicePorts(cfg ConfigValues, resolver *portsResolver) map[string][]string {
+	ports := map[string][]string{}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+		if dockerIsContainerPresent(serviceContainerName(cfg, name)) {
+			continue
+		}
+		ports[name] = resolver.resolve(name, cfg.Services[name].Ports)
+	}
+	return ports
+}
+
+// serviceStart starts the service containers of the dev container that are
+// not running yet, creating the ones that do not exist. Services are started
+// after the services they depend on meet their conditions. ports are the
+// resolved ports of the services to create.
+func serviceStart(cfg ConfigValues, ports map[string][]string) {
+	if len(cfg.Services) > 0 && !networkIsManaged(cfg.Network) {
+		log.Fatalf("ERROR: Services need a network where the dev container can reach them by name, but the network is %s; use network: project", cfg.Network)
+	}
+
+	for _, name := range serviceOrder(cfg) {
+		container := serviceContainerName(cfg, name)
+		present := dockerIsContainerPresent(container)
+		if present && dockerIsContainerRunning(container) {
+			continue
+		}
+
+		healthWaitDependencies(cfg, cfg.Services[name].DependsOn)
+		fmt.Printf("* Starting service %s\n", name)
+		if present {
+			dockerRunCmd(dockerConstructCmd("start", nil, container))
+		} else {
+			pullEnsure(cfg, cfg.Services[name].Image)
+			dockerRunCmd(serviceDockerCmd(cfg, name, ports[name]))
+		}
+	}
+}
+
+// serviceOrder returns the names of the services in the order to start them:
+// every service comes after the services it depends on.
+func serviceOrder(cfg ConfigValues) []string {
+	var order []string
+	visiting := map[string]bool{}
+	visited := map[string]bool{}
+	var visit func(name string, path []string)
+	visit = func(name string, path []string) {
+		if visited[name] {
+			return
+		}
+		path = append(path, name)
+		if visiting[name] {
+			log.Fatalf("ERROR: Services depend on each other in a cycle: %s", strings.Join(path, " -> "))
+		}
+		visiting[name] = true
+		for _, dep := range slices.Sorted(maps.Keys(cfg.Services[name].DependsOn)) {
+			visit(dep, path)
+		}
+		visited[name] = true
+		order = append(order, name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+		visit(name, nil)
+	}
+	return order
+}
+
+// serviceDockerCmd constructs the docker command that creates the container
+// of a service.
+func serviceDockerCmd(cfg ConfigValues, name string, ports []string) string {
+	svc := cfg.Services[name]
+	if svc.Image == "" {
+		log.Fatalf("ERROR: Docker image for the service %s is not specified", name)
+	}
+
+	opts := []string{
+		"--name " + serviceContainerName(cfg, name),
+		"--hostname " + shellQuote(name),
+		"--detach",
+		"--network " + shellQuote(cfg.Network),
+		"--network-alias " + shellQuote(serviceAlias(cfg, name)),
+		dockerLabelOpt("service", name),
+		dockerLabelOpt("container", cfg.ContainerName),
+		dockerLabelOpt("project", configProjectDir()),
+	}
+	for _, port := range ports {
+		opts = append(opts, "--publish "+port)
+	}
+	for _, volume := range svc.Volumes {
+		opts = append(opts, "--volume "+shellQuote(volume))
+	}
+	opts = append(opts, healthcheckOpts(svc.Healthcheck)...)
+	for _, env := range slices.Sorted(maps.Keys(svc.Env)) {
+		opts = append(opts, "--env "+shellQuote(env+"="+svc.Env[env]))
+	}
+
+	return dockerConstructCmd("run", opts, shellQuote(svc.Image))
+}
+
+// serviceContainer describes an existing service container.
+type serviceContainer struct {
+	name    string
+	service string
+	image   string
+	status  string
+}
+
+// serviceContainers returns the service containers of the dev container,
+// including the ones of services no longer in the config.
+func serviceContainers(cfg ConfigValues) []serviceContainer {
+	opts := []string{
+		"--all",
+		"--filter label=" + shellQuote(dockerLabelPrefix+"container="+cfg.ContainerName),
+		"--format " + shellQuote(`{{.Names}}|{{.Label "`+dockerLabelPrefix+`service"}}|{{.Image}}|{{.Status}}`),
+	}
+	out := dockerRunCmd(dockerConstructCmd("ps", opts))
+
+	var containers []serviceContainer
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 4 {
+			continue
+		}
+		containers = append(containers, serviceContainer{
+			name:    fields[0],
+			service: fields[1],
+			image:   fields[2],
+			status:  fields[3],
+		})
+	}
+	slices.SortFunc(containers, func(a, b serviceContainer) int { return strings.Compare(a.service, b.service) })
+	return containers
+}
+
+// serviceStop stops and removes the service containers of the dev container.
+// Named volumes of the services are kept.
+func serviceStop(cfg ConfigValues) {
+	for _, c := range serviceContainers(cfg) {
+		dockerRunCmd(dockerConstructCmd("stop", nil, c.name))
+		dockerRunCmd(dockerConstructCmd("rm", []string{"--volumes"}, c.name))
+		fmt.Printf("* Stopped service %s\n", c.service)
+	}
+}
+
+// serviceDisplay prints the service containers of the dev container.
+func serviceDisplay(cfg ConfigValues) {
+	containers := serviceContainers(cfg)
+	if len(containers) == 0 {
+		return
+	}
+
+	fmt.Printf("* %d service(s):\n", len(containers))
+	for _, c := range containers {
+		fmt.Printf("  - %s (%s): %s\n", c.service, c.image, c.status)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// session is a shell session opened into a dev container by devsh. Sessions
+// are tracked in a registry on the host, one file per session, named after
+// the ID of the devsh process that runs it.
+type session struct {
+	Pid         int       `yaml:"pid"`
+	ContainerId string    `yaml:"container_id"`
+	Started     time.Time `yaml:"started"`
+	Command     string    `yaml:"command"`
+}
+
+// sessionDir returns the folder with the session registry of a container.
+func sessionDir(containerName string) string {
+	return filepath.Join(configStateDir(), "sessions", containerName)
+}
+
+// sessionBegin registers a new session of the current process in the dev
+// container and returns a function that unregisters it.
+func sessionBegin(cfg ConfigValues, command string) func() {
+	if !dockerIsContainerPresent(cfg.ContainerName) {
+		log.Fatalf("ERROR: The dev container %s does not exist, start it first", cfg.ContainerName)
+	}
+
+	dir := sessionDir(cfg.ContainerName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalf("ERROR: Failed to create session registry %s: %s", dir, err)
+	}
+
+	s := session{
+		Pid:         os.Getpid(),
+		ContainerId: dockerContainerId(cfg.ContainerName),
+		Started:     time.Now(),
+		Command:     command,
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		log.Fatalf("ERROR: Failed to serialize session: %s", err)
+	}
+	path := filepath.Join(dir, strconv.Itoa(s.Pid))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatalf("ERROR: Failed to register session %s: %s", path, err)
+	}
+
+	return func() {
+		os.Remove(path)
+	}
+}
+
+// sessionList returns the open sessions of the dev container, oldest first.
+// Registry entries of sessions whose devsh process is gone, or which belong
+// to a previous container with the same name, are removed on the way.
+func sessionList(cfg ConfigValues) []session {
+	dir := sessionDir(cfg.ContainerName)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Fatalf("ERROR: Failed to read session registry %s: %s", dir, err)
+	}
+
+	containerId := ""
+	if dockerIsContainerPresent(cfg.ContainerName) {
+		containerId = dockerContainerId(cfg.ContainerName)
+	}
+
+	var sessions []session
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var s session
+		if err := yaml.Unmarshal(data, &s); err != nil || s.ContainerId != containerId || !sessionIsAlive(s.Pid) {
+			os.Remove(path)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+
+	slices.SortFunc(sessions, func(a, b session) int { return a.Started.Compare(b.Started) })
+	return sessions
+}
+
+// sessionIsAlive returns true if the process with the given ID is running.
+func sessionIsAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// sessionCount returns the number of sessions open in the dev container: the
+// sessions opened by devsh, or the commands running in the container via
+// docker exec (which includes sessions not opened by devsh), whichever is
+// greater.
+func sessionCount(cfg ConfigValues) int {
+	return max(len(sessionList(cfg)), dockerContainerExecCount(cfg.ContainerName))
+}
+
+// sessionDisplay prints the open sessions of the dev container.
+func sessionDisplay(cfg ConfigValues) {
+	sessions := sessionList(cfg)
+	execs := dockerContainerExecCount(cfg.ContainerName)
+	if len(sessions) == 0 && execs == 0 {
+		return
+	}
+
+	fmt.Printf("* %d open session(s):\n", max(len(sessions), execs))
+	for _, s := range sessions {
+		fmt.Printf("  - %s (pid %d), started %s ago\n", s.Command, s.Pid, time.Since(s.Started).Round(time.Second))
+	}
+	if other := execs - len(sessions); other > 0 {
+		fmt.Printf("  - %d command(s) not started by devsh (docker exec)\n", other)
+	}
+}
+
+// sessionConfirm asks the user to confirm an action despite the open sessions
+// of the dev container. It returns false if the user declines or if stdin is
+// not a terminal, so no confirmation can be asked for.
+func sessionConfirm(question string) bool {
+	if !termIsTerminal(os.Stdin.Fd()) {
+		return false
+	}
+
+	fmt.Printf("%s [y/N] ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// sessionsCmd represents the sessions command
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List the persistent sessions in the dev container",
+	Long: `List the persistent sessions in the dev container, as started with
+"devsh open --session <name>".
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configLoad(cmd)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SESSION\tHELD BY\tSTATE")
+		for _, s := range muxList(cfg) {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.name, s.backend, muxDescribe(s))
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// prefix of the image names of the snapshots, followed by the container
+	snapshotImagePrefix = "devsh-snapshot-"
+
+	// value of the snapshot key that selects the most recent snapshot
+	snapshotLatest = "latest"
+)
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot [tag]",
+	Short: "Save the dev container as an image",
+	Long: `Save the current state of the dev container as an image, e.g. after installing
+tools interactively, so that it survives "devsh stop". The image is named
+devsh-snapshot-<container_name>:<tag>, the tag defaults to the current time.
+
+Start a dev container from a snapshot with the snapshot key or the --snapshot
+flag: latest selects the most recent snapshot, any other value the snapshot
+with that tag.
+
+Snapshots contain the files of the container itself; the project folder, the
+volumes, the caches and the persistent home folder are not part of them. The
+environment variables set by devsh (env, the SSH agent, the git config) are
+reset to the values of the image, so that no secrets are saved in a snapshot.
+
+For example:
+	devsh snapshot # saves the dev container as devsh-snapshot-<container_name>:20241019-153000
+	devsh snapshot tools
+	devsh snapshot ls
+	devsh stop && devsh --snapshot latest
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configLoad(cmd)
+		if !dockerIsContainerPresent(cfg.ContainerName) {
+			log.Fatalf("ERROR: The dev container %s does not exist, start it first", cfg.ContainerName)
+		}
+
+		created := time.Now()
+		tag := created.Format("20060102-150405")
+		if len(args) == 1 {
+			tag = args[0]
+		}
+		if tag == snapshotLatest {
+			log.Fatalf("ERROR: Tag %s is reserved for the most recent snapshot", snapshotLatest)
+		}
+		image := snapshotRepository(cfg) + ":" + tag
+
+		opts := []string{
+			"--change " + shellQuote("LABEL "+dockerLabelPrefix+"snapshot="+snapshotQuote(cfg.ContainerName)),
+			"--change " + shellQuote("LABEL "+dockerLabelPrefix+"project="+snapshotQuote(configProjectDir())),
+			"--change " + shellQuote("LABEL "+dockerLabelPrefix+"created="+snapshotQuote(created.Format(time.RFC3339))),
+		}
+		for _, change := range snapshotEnvChanges(cfg.ContainerName) {
+			opts = append(opts, "--change "+shellQuote(change))
+		}
+		dockerRunCmd(dockerConstructCmd("commit", opts, cfg.ContainerName, shellQuote(image)))
+		fmt.Printf("* Saved the dev container %s as %s\n", cfg.ContainerName, image)
+	},
+}
+
+// snapshotQuote quotes a value of a LABEL or ENV instruction given to docker
+// commit, which are parsed like in a Dockerfile.
+func snapshotQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`).Replace(value) + `"`
+}
+
+// snapshotEnvChanges returns the ENV instructions that reset the environment
+// variables devsh sets when it creates the dev container (e.g. env, the SSH
+// agent and the git config) to their values in the image of the container.
+// docker commit would otherwise save them in the snapshot, including secrets
+// set in env. A variable the image does not set is reset to an empty value,
+// as it cannot be removed.
+func snapshotEnvChanges(container string) []string {
+	containerEnv := snapshotEnv(dockerConstructCmd("inspect", []string{"-f '{{json .Config.Env}}'"}, container))
+	imageEnv := snapshotEnv(dockerConstructCmd("image", []string{"inspect", "-f '{{json .Config.Env}}'"}, shellQuote(dockerContainerImageId(container))))
+
+	var changes []string
+	for _, name := range slices.Sorted(maps.Keys(containerEnv)) {
+		if value, ok := imageEnv[name]; !ok || value != containerEnv[name] {
+			changes = append(changes, "ENV "+name+"="+snapshotQuote(value))
+		}
+	}
+	return changes
+}
+
+// snapshotEnv returns the environment variables printed as a JSON list of
+// NAME=VALUE entries by a docker inspect command.
+func snapshotEnv(inspectCmd string) map[string]string {
+	var entries []string
+	if err := json.Unmarshal([]byte(dockerRunCmd(inspectCmd)), &entries); err != nil {
+		log.Fatalf("ERROR: Failed to read the environment of the dev container: %s", err)
+	}
+	env := map[string]string{}
+	for _, entry := range entries {
+		name, value, _ := strings.Cut(entry, "=")
+		env[name] = value
+	}
+	return env
+}
+
+// snapshotLsCmd represents the snapshot ls command
+var snapshotLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the snapshots of the dev container",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configLoad(cmd)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "IMAGE\tCREATED\tSIZE")
+		for _, s := range snapshotList(cfg) {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.image, s.createdSince, s.size)
+		}
+		w.Flush()
+	},
+}
+
+// snapshot describes an existing snapshot of the dev container.
+type snapshot struct {
+	image        string
+	created      time.Time
+	createdSince string // e.g. 2 days ago
+	size         string
+}
+
+// snapshotRepository returns the image name of the snapshots of the dev
+// container, without the tag.
+func snapshotRepository(cfg ConfigValues) string {
+	return snapshotImagePrefix + strings.ToLower(cfg.ContainerName)
+}
+
+// snapshotList returns the snapshots of the dev container, the most recent
+// one first.
+func snapshotList(cfg ConfigValues) []snapshot {
+	opts := []string{
+		"ls",
+		"--filter label=" + shellQuote(dockerLabelPrefix+"snapshot="+cfg.ContainerName),
+		"--format " + shellQuote(`{{.Repository}}:{{.Tag}}|{{.CreatedAt}}|{{.CreatedSince}}|{{.Size}}`),
+	}
+	out := dockerRunCmd(dockerConstructCmd("image", opts))
+
+	var snapshots []snapshot
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 4 {
+			continue
+		}
+		// e.g. 2024-10-19 15:30:00 +0200 CEST
+		created, err := time.Parse("2006-01-02 15:04:05 -0700 MST", fields[1])
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{fields[0], created, fields[2], fields[3]})
+	}
+	slices.SortFunc(snapshots, func(a, b snapshot) int { return b.created.Compare(a.created) })
+	return snapshots
+}
+
+// snapshotImage returns the image of the snapshot selected by the snapshot
+// key, or an empty string to start from the configured image. Without any
+// snapshot, latest falls back to the configured image.
+func snapshotImage(cfg ConfigValues) string {
+	switch cfg.Snapshot {
+	case "":
+		return ""
+	case snapshotLatest:
+		snapshots := snapshotList(cfg)
+		if len(snapshots) == 0 {
+			log.Printf("WARN: The dev container %s has no snapshots, starting from the image %s", cfg.ContainerName, cfg.Image)
+			return ""
+		}
+		return snapshots[0].image
+	}
+	image := snapshotRepository(cfg) + ":" + cfg.Snapshot
+	if !dockerIsImagePresent(image) {
+		log.Fatalf("ERROR: Snapshot %s is not found, see `devsh snapshot ls`", image)
+	}
+	return image
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotLsCmd)
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+const (
+	// path of the forwarded agent socket inside the dev container
+	sshAgentContainerSock = "/run/devsh/ssh-agent.sock"
+
+	// Docker Desktop for Mac cannot mount host sockets, it provides the
+	// host's agent at this path in its VM instead
+	sshAgentDockerDesktopSock = "/run/host-services/ssh-auth.sock"
+)
+
+// sshAgentHostSock returns the socket of the SSH agent running on the host,
+// as it is to be mounted into the dev container. It fails when no agent is
+// running.
+func sshAgentHostSock() string {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		log.Fatal("ERROR: ssh_agent is enabled, but no SSH agent is running on the host (SSH_AUTH_SOCK is not set). Start one with `eval $(ssh-agent)` and add your keys with `ssh-add`, or disable ssh_agent.")
+	}
+	info, err := os.Stat(sock)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		log.Fatalf("ERROR: ssh_agent is enabled, but the SSH agent socket %s (SSH_AUTH_SOCK) does not exist. Is the SSH agent running?", sock)
+	}
+
+	if runtime.GOOS == "darwin" {
+		return sshAgentDockerDesktopSock
+	}
+	return sock
+}
+
+// sshAgentHostId identifies the socket of the host's SSH agent by its path and
+// inode, as a restarted agent creates a new socket, possibly at the same path.
+// The socket provided by Docker Desktop does not change.
+func sshAgentHostId() string {
+	sock := sshAgentHostSock()
+	if sock == sshAgentDockerDesktopSock {
+		return sock
+	}
+	info, err := os.Stat(sock)
+	if err != nil {
+		return sock
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%s#%d", sock, stat.Ino)
+	}
+	return sock
+}
+
+// sshAgentRunOpts returns the options that forward the host's SSH agent into
+// the dev container when it is created.
+func sshAgentRunOpts(cfg ConfigValues) []string {
+	if !configBool(cfg.SSHAgent) {
+		return nil
+	}
+	return []string{
+		"--volume " + shellQuote(sshAgentHostSock()+":"+sshAgentContainerSock),
+		"--env SSH_AUTH_SOCK=" + sshAgentContainerSock,
+		// the socket mounted into the container, to detect when it changes
+		dockerLabelOpt("ssh_agent", sshAgentHostId()),
+	}
+}
+
+// sshAgentExecOpts returns the options that make the forwarded SSH agent
+// available to a command executed in the dev container.
+func sshAgentExecOpts(cfg ConfigValues) []string {
+	if !configBool(cfg.SSHAgent) {
+		return nil
+	}
+	return []string{
+		"--env SSH_AUTH_SOCK=" + sshAgentContainerSock,
+	}
+}
+
+// sshAgentCheck warns when the dev container still has the socket of an
+// agent that is gone, i.e. the SSH agent of the host changed since the dev
+// container was created. It is called once before a shell is opened.
+func sshAgentCheck(cfg ConfigValues) {
+	if !configBool(cfg.SSHAgent) {
+		return
+	}
+	mounted := dockerContainerLabel(cfg.ContainerName, "ssh_agent")
+	if current := sshAgentHostId(); mounted != "" && mounted != current {
+		log.Printf("WARN: The SSH agent of the host has changed since the dev container was created, it is not available in the container. Recreate the dev container with `devsh stop && devsh` to forward the current agent.")
+	}
+}
@@ -3,7 +3,10 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
+	"maps"
+	"slices"
 
 	"github.com/spf13/cobra"
 )
@@ -19,8 +22,7 @@ var startCmd = &cobra.Command{
 		cfg := startContainerConfig(cmd)
 
 		if !dockerIsContainerPresent(cfg.ContainerName) {
-			dockerCmd := startDockerCmd(cfg)
-			dockerRunCmd(dockerCmd)
+			startContainer(cfg)
 		}
 
 		statusDisplay(cfg)
@@ -45,6 +47,81 @@ func startContainerConfig(cmd *cobra.Command) ConfigValues {
 	return cfg
 }
 
+// Creates and starts the dev container along with its services, building its
+// image first if needed, waits for it to be ready, runs the post-create command
+// inside it and installs the dotfiles
+func startContainer(cfg ConfigValues) {
+	configReportWarnings()
+	healthValidate(cfg)
+	cfg = lockApply(cfg)
+	resolver := portsNewResolver(cfg)
+	serviceResolvedPorts := servicePorts(cfg, resolver)
+	cfg.Ports = resolver.resolve("", cfg.Ports)
+	resolver.save()
+
+	if image := snapshotImage(cfg); image != "" {
+		fmt.Printf("* Starting from the snapshot %s\n", image)
+		cfg.Image = image
+	} else if cfg.Build != nil {
+		if !dockerIsImagePresent(cfg.Image) {
+			startBuildImage(cfg, false)
+		}
+	} else {
+		pullEnsure(cfg, cfg.Image)
+	}
+	networkCreate(cfg)
+	serviceStart(cfg, serviceResolvedPorts)
+	cacheCreateVolumes(cfg)
+	if configBool(cfg.PersistHome) {
+		cfg.Volumes = append(cfg.Volumes, homeVolume(cfg))
+	}
+
+	dockerCmd := startDockerCmd(cfg)
+	dockerRunCmd(dockerCmd)
+	healthWaitReady(cfg)
+
+	if cfg.PostCreateCmd != "" {
+		opts := append(openExecOpts(cfg), "--workdir "+cfg.ContainerDir)
+		execCmd := dockerConstructCmd("exec", opts, cfg.ContainerName, "/bin/sh -c", shellQuote(cfg.PostCreateCmd))
+		if err := dockerRunStreamed(execCmd); err != nil {
+			log.Fatalf("ERROR: Post-create command failed in the dev container %s: %s", cfg.ContainerName, err)
+		}
+	}
+
+	dotfilesInstall(cfg)
+}
+
+// Builds the image for the dev container from its build configuration. With
+// pull, the latest version of the base image is pulled first.
+func startBuildImage(cfg ConfigValues, pull bool) {
+	build := cfg.Build
+	opts := []string{
+		"--tag " + shellQuote(cfg.Image),
+	}
+	if pull {
+		opts = append(opts, "--pull")
+	}
+	if build.Dockerfile != "" {
+		opts = append(opts, "--file "+shellQuote(build.Dockerfile))
+	}
+	for _, name := range slices.Sorted(maps.Keys(build.Args)) {
+		opts = append(opts, "--build-arg "+shellQuote(name+"="+build.Args[name]))
+	}
+	if build.Target != "" {
+		opts = append(opts, "--target "+shellQuote(build.Target))
+	}
+
+	context := build.Context
+	if context == "" {
+		context = configProjectDir()
+	}
+
+	buildCmd := dockerConstructCmd("build", opts, shellQuote(context))
+	if err := dockerRunStreamed(buildCmd); err != nil {
+		log.Fatalf("ERROR: Failed to build image %s for the dev container: %s", cfg.Image, err)
+	}
+}
+
 // Constructs the docker command from the dev container configuration
 func startDockerCmd(cfg ConfigValues) string {
 	opts := []string{
@@ -54,9 +131,8 @@ func startDockerCmd(cfg ConfigValues) string {
 		"--detach",
 		"-t", // allocate a pseudo-TTY so the container's main process stays alive
 	}
-	if cfg.Network != "" {
-		opts = append(opts, "--network "+cfg.Network)
-	}
+	opts = append(opts, networkRunOpts(cfg)...)
+	opts = append(opts, healthcheckOpts(cfg.Healthcheck)...)
 	if cfg.DNS != "" {
 		opts = append(opts, "--dns "+cfg.DNS)
 	}
@@ -66,6 +142,12 @@ func startDockerCmd(cfg ConfigValues) string {
 	for _, volume := range cfg.Volumes {
 		opts = append(opts, "--volume "+volume)
 	}
+	opts = append(opts, cacheVolumeOpts(cfg)...)
+	opts = append(opts, sshAgentRunOpts(cfg)...)
+	opts = append(opts, gitRunOpts(cfg)...)
+	for _, name := range slices.Sorted(maps.Keys(cfg.Env)) {
+		opts = append(opts, "--env "+shellQuote(name+"="+cfg.Env[name]))
+	}
 
 	return dockerConstructCmd("run", opts, cfg.Image)
 }
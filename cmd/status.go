@@ -25,12 +25,18 @@ func statusDisplay(cfg ConfigValues) {
 	if dockerIsContainerPresent(containerName) {
 		if dockerIsContainerRunning(containerName) {
 			fmt.Printf("* Dev container %s is running (%s)\n", containerName, dockerContainerIdShort(containerName))
+			portsDisplay(cfg)
+			forwardDisplay(cfg)
+			sessionDisplay(cfg)
+			muxDisplay(muxList(cfg))
 		} else {
 			fmt.Printf("* Dev container %s is stopped (%s)\n", containerName, dockerContainerIdShort(containerName))
 		}
+		pullDisplayOutdated(cfg)
 	} else {
 		fmt.Printf("* Dev container %s does not exist (stopped and/or removed)\n", containerName)
 	}
+	serviceDisplay(cfg)
 }
 
 func init() {
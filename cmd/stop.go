@@ -3,6 +3,9 @@
 package cmd
 
 import (
+	"fmt"
+	"log"
+
 	"github.com/spf13/cobra"
 )
 
@@ -12,11 +15,28 @@ var stopCmd = &cobra.Command{
 	Short: "Stop the dev container",
 	Long: `Stop the development container.
 
+If shells are still open in the dev container, or persistent sessions are
+running in it, devsh asks for confirmation before stopping it (or refuses when
+it cannot ask). Use --force to stop the dev container regardless. Ports
+forwarded with "devsh forward" and the containers of the services are stopped
+along with the dev container.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		cfg := configLoad(cmd)
 
 		if dockerIsContainerPresent(cfg.ContainerName) {
+			force, _ := cmd.Flags().GetBool("force")
+			n, persistent := sessionCount(cfg), muxList(cfg)
+			if (n > 0 || len(persistent) > 0) && !force {
+				sessionDisplay(cfg)
+				muxDisplay(persistent)
+				if !sessionConfirm(fmt.Sprintf("The dev container %s has open or persistent sessions. Stop it anyway?", cfg.ContainerName)) {
+					log.Fatalf("ERROR: The dev container %s has open or persistent sessions, use --force to stop it anyway", cfg.ContainerName)
+				}
+			}
+
+			forwardStop(cfg, nil)
+
 			// Stop the container gracefully with a short timeout. `docker stop`
 			// sends SIGTERM to PID 1 and waits up to the timeout (here 1s) for
 			// the process to exit on its own before escalating to SIGKILL. The
@@ -29,6 +49,10 @@ var stopCmd = &cobra.Command{
 			rmCmd := dockerConstructCmd("rm", nil, cfg.ContainerName)
 			dockerRunCmd(rmCmd)
 		}
+		// services are stopped even without the dev container, e.g. when it
+		// failed to start
+		serviceStop(cfg)
+		networkRemoveUnused(cfg)
 
 		statusDisplay(cfg)
 	},
@@ -37,6 +61,8 @@ var stopCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(stopCmd)
 
+	stopCmd.Flags().BoolP("force", "f", false, "Stop the dev container even if sessions are open in it")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
@@ -0,0 +1,75 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// termIoctl performs an ioctl system call on a file descriptor.
+func termIoctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// termIsTerminal returns true if the file descriptor refers to a terminal.
+func termIsTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return termIoctl(fd, termIoctlGetAttr, unsafe.Pointer(&termios)) == nil
+}
+
+// termMakeRaw puts the terminal into raw mode (as cfmakeraw does) and returns
+// its previous state, to be restored with termRestore.
+func termMakeRaw(fd uintptr) (*syscall.Termios, error) {
+	var state syscall.Termios
+	if err := termIoctl(fd, termIoctlGetAttr, unsafe.Pointer(&state)); err != nil {
+		return nil, err
+	}
+
+	raw := state
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := termIoctl(fd, termIoctlSetAttr, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// termRestore restores the terminal state saved by termMakeRaw.
+func termRestore(fd uintptr, state *syscall.Termios) error {
+	return termIoctl(fd, termIoctlSetAttr, unsafe.Pointer(state))
+}
+
+// termWinsize is the size of a terminal window, as used by the TIOCGWINSZ and
+// TIOCSWINSZ ioctls.
+type termWinsize struct {
+	Rows   uint16
+	Cols   uint16
+	xpixel uint16
+	ypixel uint16
+}
+
+// termGetSize returns the size of the terminal.
+func termGetSize(fd uintptr) (rows, cols uint16, err error) {
+	var ws termWinsize
+	if err := termIoctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return ws.Rows, ws.Cols, nil
+}
+
+// termSetSize sets the size of the terminal (e.g. of a pseudo-terminal).
+func termSetSize(fd uintptr, rows, cols uint16) error {
+	ws := termWinsize{Rows: rows, Cols: cols}
+	return termIoctl(fd, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
@@ -0,0 +1,10 @@
+// Copyright 2024 The devsh authors
+
+package cmd
+
+import "syscall"
+
+const (
+	termIoctlGetAttr = syscall.TCGETS
+	termIoctlSetAttr = syscall.TCSETS
+)